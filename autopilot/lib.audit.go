@@ -0,0 +1,342 @@
+package autopilot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+const (
+	AuditOperationNodePatch      = "node.patch"
+	AuditOperationNodeDrain      = "node.drain"
+	AuditOperationNodeCordon     = "node.cordon"
+	AuditOperationNodeUncordon   = "node.uncordon"
+	AuditOperationNodeDelete     = "node.delete"
+	AuditOperationNodeDeletePods = "node.force-delete-pods"
+
+	AuditOutcomeSucceeded = "succeeded"
+	AuditOutcomeFailed    = "failed"
+
+	AuditTriggerCron        = "cron"
+	AuditTriggerEvent       = "event"
+	AuditTriggerAlert       = "alert"
+	AuditTriggerLockExpired = "lock-expired"
+	AuditTriggerMissingVm   = "missing-vm"
+
+	auditConfigMapKey     = "audit.jsonl"
+	auditConfigMapTimeout = 10 * time.Second
+)
+
+type (
+	// audit log entry of a mutating action (K8s or Azure)
+	auditRecord struct {
+		Time               time.Time       `json:"time"`
+		Instance           string          `json:"instance,omitempty"`
+		Task               string          `json:"task,omitempty"`
+		Trigger            string          `json:"trigger,omitempty"`
+		Operation          string          `json:"operation"`
+		Action             string          `json:"action,omitempty"`
+		Node               string          `json:"node,omitempty"`
+		ResourceId         string          `json:"resourceId,omitempty"`
+		Inputs             map[string]any  `json:"inputs,omitempty"`
+		Patches            []k8s.JsonPatch `json:"patches,omitempty"`
+		DryRun             bool            `json:"dryRun"`
+		Outcome            string          `json:"outcome"`
+		Error              string          `json:"error,omitempty"`
+		DurationSeconds    float64         `json:"durationSeconds,omitempty"`
+		AzureCorrelationId string          `json:"azureCorrelationId,omitempty"`
+	}
+
+	// task and trigger of mutating actions, passed by context
+	auditTrigger struct {
+		task    string
+		trigger string
+	}
+
+	auditTriggerKey struct{}
+	auditTargetsKey struct{}
+)
+
+// open audit log sinks (file/stdout and ConfigMap)
+func (r *AzureK8sAutopilot) initAudit() {
+	switch r.Config.Audit.Log {
+	case "":
+	case "-":
+		r.auditLog.writer = os.Stdout
+	default:
+		file, err := os.OpenFile(r.Config.Audit.Log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			r.Logger.Panic(fmt.Sprintf("unable to open audit log: %v", err))
+		}
+		r.auditLog.writer = file
+	}
+
+	if r.Config.Audit.ConfigMap != "" {
+		r.auditLog.namespace = r.instanceNamespace()
+		if r.auditLog.namespace == "" {
+			r.Logger.Panic("unable to detect namespace for audit ConfigMap, set --instance.namespace")
+		}
+
+		r.auditLog.flush = make(chan struct{}, 1)
+		go func() {
+			for range r.auditLog.flush {
+				r.auditConfigMapWrite()
+			}
+		}()
+	}
+}
+
+// write pending entries and close audit log file
+func (r *AzureK8sAutopilot) shutdownAudit() {
+	if r.auditLog.flush != nil {
+		r.auditConfigMapWrite()
+	}
+
+	if r.auditLog.writer != nil && r.auditLog.writer != os.Stdout {
+		if closer, ok := r.auditLog.writer.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				r.Logger.Error("unable to close audit log", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func (r *AzureK8sAutopilot) auditEnabled() bool {
+	return r.auditLog.writer != nil || r.auditLog.flush != nil
+}
+
+// sets task and trigger for audit entries of all actions using the context
+func auditContext(ctx context.Context, task, trigger string) context.Context {
+	return context.WithValue(ctx, auditTriggerKey{}, auditTrigger{task: task, trigger: trigger})
+}
+
+// copies audit task and trigger to another context
+func auditContextCopy(ctx, from context.Context) context.Context {
+	if trigger, ok := from.Value(auditTriggerKey{}).(auditTrigger); ok {
+		return context.WithValue(ctx, auditTriggerKey{}, trigger)
+	}
+	return ctx
+}
+
+// sets node targets of the following actions, used for action and decision inputs of audit entries
+func auditTargetContext(ctx context.Context, targetList ...*nodeTarget) context.Context {
+	return context.WithValue(ctx, auditTargetsKey{}, targetList)
+}
+
+func auditTargets(ctx context.Context) []*nodeTarget {
+	if targetList, ok := ctx.Value(auditTargetsKey{}).([]*nodeTarget); ok {
+		return targetList
+	}
+	return nil
+}
+
+// write audit entry, task and trigger are taken from context if not set
+func (r *AzureK8sAutopilot) audit(ctx context.Context, record auditRecord) {
+	if !r.auditEnabled() {
+		return
+	}
+
+	record.Time = time.Now()
+	record.DryRun = record.DryRun || r.Config.DryRun
+	if r.Config.Instance.Pod != nil {
+		record.Instance = *r.Config.Instance.Pod
+	}
+	if trigger, ok := ctx.Value(auditTriggerKey{}).(auditTrigger); ok {
+		if record.Task == "" {
+			record.Task = trigger.task
+		}
+		record.Trigger = trigger.trigger
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		r.Logger.Error("unable to marshal audit entry", slog.Any("error", err))
+		return
+	}
+
+	r.auditLog.lock.Lock()
+	defer r.auditLog.lock.Unlock()
+
+	if r.auditLog.writer != nil {
+		if _, err := r.auditLog.writer.Write(append(line, '\n')); err != nil {
+			r.Logger.Error("unable to write audit entry", slog.Any("error", err))
+		}
+	}
+
+	if r.auditLog.flush != nil {
+		r.auditLog.entries = append(r.auditLog.entries, string(line))
+		if overflow := len(r.auditLog.entries) - r.Config.Audit.ConfigMapSize; overflow > 0 {
+			r.auditLog.entries = r.auditLog.entries[overflow:]
+		}
+
+		select {
+		case r.auditLog.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// audit entry for K8s node action, action and inputs are taken from the node target in context
+func (r *AzureK8sAutopilot) auditNode(ctx context.Context, operation string, node *k8s.Node, start time.Time, dryRun bool, err error) {
+	record := auditRecord{
+		Operation:       operation,
+		Node:            node.Name,
+		ResourceId:      node.Spec.ProviderID,
+		DryRun:          dryRun,
+		Outcome:         auditOutcome(err),
+		Error:           notificationError(err),
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+
+	for _, target := range auditTargets(ctx) {
+		if target.node != nil && target.node.Name == node.Name {
+			record.Action = target.action
+			record.Inputs = target.inputs
+		}
+	}
+
+	r.audit(ctx, record)
+}
+
+// audit entries for Azure operation, one entry per node target in context
+func (r *AzureK8sAutopilot) auditAzureOperation(ctx context.Context, operation, action string, info k8s.NodeInfo, start time.Time, err error) {
+	record := auditRecord{
+		Operation:       operation,
+		Action:          action,
+		Outcome:         auditOutcome(err),
+		Error:           notificationError(err),
+		DurationSeconds: time.Since(start).Seconds(),
+	}
+
+	targetList := auditTargets(ctx)
+	if len(targetList) == 0 {
+		record.ResourceId = info.ProviderId
+		r.audit(ctx, record)
+		return
+	}
+
+	for _, target := range targetList {
+		record.Inputs = target.inputs
+		record.AzureCorrelationId = target.correlationId
+		if target.node != nil {
+			record.Node = target.node.Name
+		}
+		if target.info != nil {
+			record.ResourceId = target.info.ProviderId
+		}
+		r.audit(ctx, record)
+	}
+}
+
+// audit entry for node patch (annotations, taints), called by k8s.Node
+// action and inputs are taken from the node target in context
+func (r *AzureK8sAutopilot) auditNodePatch(ctx context.Context, node *k8s.Node, patches []k8s.JsonPatch, err error) {
+	record := auditRecord{
+		Operation: AuditOperationNodePatch,
+		Node:      node.Name,
+		Patches:   patches,
+		Outcome:   auditOutcome(err),
+		Error:     notificationError(err),
+	}
+
+	for _, target := range auditTargets(ctx) {
+		if target.node != nil && target.node.Name == node.Name {
+			record.Action = target.action
+			record.Inputs = target.inputs
+		}
+	}
+
+	r.audit(ctx, record)
+}
+
+func auditOutcome(err error) string {
+	if err != nil {
+		return AuditOutcomeFailed
+	}
+	return AuditOutcomeSucceeded
+}
+
+// append pending entries to audit ConfigMap, the ConfigMap is re-read before each write
+// so entries written by other instances (eg. previous leader) are kept
+func (r *AzureK8sAutopilot) auditConfigMapWrite() {
+	r.auditLog.lock.Lock()
+	pending := r.auditLog.entries
+	r.auditLog.entries = nil
+	r.auditLog.lock.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), auditConfigMapTimeout)
+	defer cancel()
+
+	client := r.k8sClient.CoreV1().ConfigMaps(r.auditLog.namespace)
+	err := retry.OnError(retry.DefaultRetry, auditConfigMapRetryable, func() error {
+		configMap, err := client.Get(ctx, r.Config.Audit.ConfigMap, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      r.Config.Audit.ConfigMap,
+					Namespace: r.auditLog.namespace,
+				},
+				Data: map[string]string{auditConfigMapKey: r.auditConfigMapMerge("", pending)},
+			}
+			_, err = client.Create(ctx, configMap, metav1.CreateOptions{})
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[auditConfigMapKey] = r.auditConfigMapMerge(configMap.Data[auditConfigMapKey], pending)
+		_, err = client.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		r.Logger.Error("unable to write audit ConfigMap", slog.Any("error", err))
+
+		// keep entries for next write
+		r.auditLog.lock.Lock()
+		r.auditLog.entries = append(pending, r.auditLog.entries...)
+		if overflow := len(r.auditLog.entries) - r.Config.Audit.ConfigMapSize; overflow > 0 {
+			r.auditLog.entries = r.auditLog.entries[overflow:]
+		}
+		r.auditLog.lock.Unlock()
+	}
+}
+
+// existing ConfigMap entries followed by pending entries, limited to the latest entries
+func (r *AzureK8sAutopilot) auditConfigMapMerge(content string, pending []string) string {
+	entries := []string{}
+	if content = strings.TrimSpace(content); content != "" {
+		entries = strings.Split(content, "\n")
+	}
+	entries = append(entries, pending...)
+
+	if overflow := len(entries) - r.Config.Audit.ConfigMapSize; overflow > 0 {
+		entries = entries[overflow:]
+	}
+
+	return strings.Join(entries, "\n")
+}
+
+// concurrent writes (update conflict or ConfigMap created by another instance) are retried
+func auditConfigMapRetryable(err error) bool {
+	return k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err)
+}
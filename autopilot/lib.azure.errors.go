@@ -0,0 +1,193 @@
+package autopilot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/webdevops/go-common/log/slogger"
+)
+
+const (
+	AzureErrorClassThrottled = "throttled"
+	AzureErrorClassConflict  = "conflict"
+	// operation denied (eg. by policy or limits), not retried
+	AzureErrorClassNotAllowed = "notallowed"
+	AzureErrorClassNotFound   = "notfound"
+	AzureErrorClassAuth       = "auth"
+	AzureErrorClassQuota      = "quota"
+	AzureErrorClassTransient  = "transient"
+	AzureErrorClassTimeout    = "timeout"
+	// operation cancelled by autopilot (eg. shutdown), not an Azure error
+	AzureErrorClassCancelled = "cancelled"
+	AzureErrorClassUnknown   = "unknown"
+)
+
+// classify Azure error for retry handling, metrics and notifications
+func azureErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		return AzureErrorClassAuth
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return AzureErrorClassCancelled
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return AzureErrorClassTimeout
+	}
+
+	var responseErr *azcore.ResponseError
+	if !errors.As(err, &responseErr) {
+		return AzureErrorClassUnknown
+	}
+
+	errorCode := strings.ToLower(responseErr.ErrorCode)
+	switch {
+	case strings.Contains(errorCode, "quota"):
+		return AzureErrorClassQuota
+	case responseErr.StatusCode == http.StatusTooManyRequests:
+		return AzureErrorClassThrottled
+	case strings.Contains(errorCode, "operationnotallowed") && responseErr.StatusCode != http.StatusConflict:
+		return AzureErrorClassNotAllowed
+	case responseErr.StatusCode == http.StatusConflict, strings.Contains(errorCode, "inprogress"):
+		return AzureErrorClassConflict
+	case responseErr.StatusCode == http.StatusNotFound:
+		return AzureErrorClassNotFound
+	case responseErr.StatusCode == http.StatusUnauthorized, responseErr.StatusCode == http.StatusForbidden:
+		return AzureErrorClassAuth
+	case responseErr.StatusCode >= 500:
+		return AzureErrorClassTransient
+	}
+
+	return AzureErrorClassUnknown
+}
+
+// check if error class should be retried
+func azureErrorIsRetryable(class string) bool {
+	switch class {
+	case AzureErrorClassThrottled, AzureErrorClassConflict, AzureErrorClassTransient:
+		return true
+	}
+	return false
+}
+
+// Retry-After header of throttled Azure response
+func azureErrorRetryAfter(err error) *time.Duration {
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) && responseErr.RawResponse != nil {
+		if val := responseErr.RawResponse.Header.Get("Retry-After"); val != "" {
+			if seconds, parseErr := strconv.Atoi(val); parseErr == nil {
+				retryAfter := time.Duration(seconds) * time.Second
+				return &retryAfter
+			}
+		}
+	}
+	return nil
+}
+
+// handles Azure error state (throttling pause, auth failures), counts the error and returns error class
+func (r *AzureK8sAutopilot) azureHandleError(contextLogger *slogger.Logger, scope string, err error) string {
+	class := r.azureHandleErrorState(contextLogger, err)
+	r.metricsAzureError(scope, class)
+	return class
+}
+
+// count Azure error, cancelled operations are not counted
+func (r *AzureK8sAutopilot) metricsAzureError(scope, class string) {
+	if class != "" && class != AzureErrorClassCancelled {
+		r.prometheus.general.errors.WithLabelValues(scope, class).Inc()
+	}
+}
+
+// handles Azure error state (throttling pause, auth failures) without counting the error and returns error class
+func (r *AzureK8sAutopilot) azureHandleErrorState(contextLogger *slogger.Logger, err error) string {
+	class := azureErrorClass(err)
+	if class == "" {
+		r.azureSetAuthError(nil)
+		return class
+	}
+
+	switch class {
+	case AzureErrorClassThrottled:
+		pause := r.Config.Azure.ThrottlePause
+		if retryAfter := azureErrorRetryAfter(err); retryAfter != nil && *retryAfter > pause {
+			pause = *retryAfter
+		}
+		r.azureSetThrottled(pause)
+		contextLogger.Warn("Azure API throttling detected, pausing Azure actions", slog.Duration("pause", pause))
+	case AzureErrorClassAuth:
+		r.azureSetAuthError(err)
+	}
+
+	return class
+}
+
+// run single Azure operation (eg. Begin* call) and retry it with backoff for transient errors,
+// the error is counted once for the operation
+func (r *AzureK8sAutopilot) azureRetry(ctx context.Context, contextLogger *slogger.Logger, scope string, operation func() error) (err error) {
+	defer func() {
+		r.metricsAzureError(scope, azureErrorClass(err))
+	}()
+
+	backoff := r.Config.Azure.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err = operation()
+		class := r.azureHandleErrorState(contextLogger, err)
+		if err == nil || !azureErrorIsRetryable(class) || attempt >= r.Config.Azure.RetryLimit {
+			return err
+		}
+
+		wait := backoff
+		if retryAfter := azureErrorRetryAfter(err); retryAfter != nil && *retryAfter > wait {
+			wait = *retryAfter
+		}
+
+		contextLogger.Warn("Azure operation failed, retrying", slog.String("errorClass", class), slog.Int("attempt", attempt+1), slog.Duration("wait", wait), slog.Any("error", err))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (r *AzureK8sAutopilot) azureSetThrottled(pause time.Duration) {
+	r.azureState.lock.Lock()
+	defer r.azureState.lock.Unlock()
+	if until := time.Now().Add(pause); until.After(r.azureState.throttledUntil) {
+		r.azureState.throttledUntil = until
+	}
+}
+
+// returns time until Azure actions are paused because of throttling
+func (r *AzureK8sAutopilot) azureIsThrottled() (bool, time.Time) {
+	r.azureState.lock.Lock()
+	defer r.azureState.lock.Unlock()
+	return time.Now().Before(r.azureState.throttledUntil), r.azureState.throttledUntil
+}
+
+func (r *AzureK8sAutopilot) azureSetAuthError(err error) {
+	r.azureState.lock.Lock()
+	defer r.azureState.lock.Unlock()
+	r.azureState.authError = err
+}
+
+// Ready returns error if autopilot is not able to work (eg. Azure authentication failed)
+func (r *AzureK8sAutopilot) Ready() error {
+	r.azureState.lock.Lock()
+	defer r.azureState.lock.Unlock()
+	return r.azureState.authError
+}
@@ -0,0 +1,50 @@
+package autopilot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+func TestAzureErrorClass(t *testing.T) {
+	responseError := func(statusCode int, errorCode string) error {
+		return &azcore.ResponseError{StatusCode: statusCode, ErrorCode: errorCode}
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		class string
+	}{
+		{name: "no error", err: nil, class: ""},
+		{name: "auth", err: &azidentity.AuthenticationFailedError{}, class: AzureErrorClassAuth},
+		{name: "deadline", err: fmt.Errorf("poll: %w", context.DeadlineExceeded), class: AzureErrorClassTimeout},
+		{name: "cancelled", err: fmt.Errorf("shutdown: %w", context.Canceled), class: AzureErrorClassCancelled},
+		{name: "other error", err: errors.New("something failed"), class: AzureErrorClassUnknown},
+		{name: "quota", err: responseError(http.StatusConflict, "OperationNotAllowed.QuotaExceeded"), class: AzureErrorClassQuota},
+		{name: "throttled", err: responseError(http.StatusTooManyRequests, "TooManyRequests"), class: AzureErrorClassThrottled},
+		{name: "not allowed", err: responseError(http.StatusBadRequest, "OperationNotAllowed"), class: AzureErrorClassNotAllowed},
+		{name: "not allowed with conflict", err: responseError(http.StatusConflict, "OperationNotAllowed"), class: AzureErrorClassConflict},
+		{name: "conflict", err: responseError(http.StatusConflict, "Conflict"), class: AzureErrorClassConflict},
+		{name: "operation in progress", err: responseError(http.StatusBadRequest, "OperationPreempted.InProgress"), class: AzureErrorClassConflict},
+		{name: "not found", err: responseError(http.StatusNotFound, "ResourceNotFound"), class: AzureErrorClassNotFound},
+		{name: "unauthorized", err: responseError(http.StatusUnauthorized, "InvalidAuthenticationToken"), class: AzureErrorClassAuth},
+		{name: "forbidden", err: responseError(http.StatusForbidden, "AuthorizationFailed"), class: AzureErrorClassAuth},
+		{name: "server error", err: responseError(http.StatusServiceUnavailable, "ServiceUnavailable"), class: AzureErrorClassTransient},
+		{name: "wrapped server error", err: fmt.Errorf("update failed: %w", responseError(http.StatusInternalServerError, "InternalError")), class: AzureErrorClassTransient},
+		{name: "bad request", err: responseError(http.StatusBadRequest, "InvalidParameter"), class: AzureErrorClassUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if class := azureErrorClass(test.err); class != test.class {
+				t.Errorf("expected class %q, got %q", test.class, class)
+			}
+		})
+	}
+}
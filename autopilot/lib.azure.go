@@ -1,214 +1,595 @@
 package autopilot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
 	"github.com/webdevops/go-common/log/slogger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/webdevopos/azure-k8s-autopilot/k8s"
 )
 
-// trigger VMSS repair task
-func (r *AzureK8sAutopilot) azureVmssInstanceRepair(contextLogger *slogger.Logger, nodeInfo k8s.NodeInfo) error {
-	vmssClient, err := armcompute.NewVirtualMachineScaleSetsClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClient.NewArmClientOptions())
+type (
+	// node with parsed Azure resource information
+	nodeTarget struct {
+		node *k8s.Node
+		info *k8s.NodeInfo
+
+		// node was drained (and cordoned) before repair
+		drained bool
+
+		// alert which triggered the repair (empty for NotReady nodes)
+		alert string
+
+		// smoke test failed, node stays cordoned
+		verificationFailed bool
+
+		// start of repair or update, used for notification durations
+		started time.Time
+
+		// correlation ID of Azure operation (x-ms-correlation-request-id)
+		correlationId string
+
+		// action of node (repair action can be overridden by alert rule)
+		action string
+
+		// inputs of the decision to act on the node (eg. health, policies), used for audit entries
+		inputs map[string]any
+	}
+)
+
+// set decision input of node target
+func (t *nodeTarget) setInput(name string, value any) {
+	if t.inputs == nil {
+		t.inputs = map[string]any{}
+	}
+	t.inputs[name] = value
+}
+
+// key of Azure resource for batched actions (VMSS for VMSS instances, VM otherwise), only same actions are batched
+// (VMSS Flex instances are addressed in their own resource group, so only instances of the same resource group are batched)
+func (t *nodeTarget) batchKey() string {
+	if t.info.IsVmssFlex {
+		return strings.ToLower(fmt.Sprintf("%s/%s/%s/%s/%s/%s", t.info.VMScaleSetSubscription, t.info.VMScaleSetResourceGroup, t.info.VMScaleSetName, t.info.Subscription, t.info.ResourceGroup, t.action))
+	}
+	if t.info.IsVmss {
+		return strings.ToLower(fmt.Sprintf("%s/%s/%s/%s", t.info.VMScaleSetSubscription, t.info.VMScaleSetResourceGroup, t.info.VMScaleSetName, t.action))
+	}
+	return strings.ToLower(t.info.ProviderId)
+}
+
+// group node targets by VMSS, VMs are not batched
+func groupNodeTargets(targetList []*nodeTarget) (groups [][]*nodeTarget) {
+	index := map[string]int{}
+	for _, target := range targetList {
+		key := target.batchKey()
+		if i, exists := index[key]; exists {
+			groups[i] = append(groups[i], target)
+		} else {
+			index[key] = len(groups)
+			groups = append(groups, []*nodeTarget{target})
+		}
+	}
+	return
+}
+
+func nodeTargetNames(targetList []*nodeTarget) (names []string) {
+	for _, target := range targetList {
+		names = append(names, target.node.Name)
+	}
+	return
+}
+
+func nodeTargetInstanceIDs(targetList []*nodeTarget) (instanceIDs []*string) {
+	for _, target := range targetList {
+		instanceIDs = append(instanceIDs, &target.info.VMInstanceID)
+	}
+	return
+}
+
+// parse node information from provider ID, VMSS Flex instances are detected using the Azure VM
+func (r *AzureK8sAutopilot) azureExtractNodeInfo(ctx context.Context, node *k8s.Node) (*k8s.NodeInfo, error) {
+	nodeInfo, err := k8s.ExtractNodeInfo(node)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	vmssVmClient, err := armcompute.NewVirtualMachineScaleSetVMsClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClient.NewArmClientOptions())
+	// VM is not in inventory cache, fetch it to detect VMSS Flex instance
+	if !nodeInfo.IsVmss && node.AzureVm == nil {
+		client, err := armcompute.NewVirtualMachinesClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		vm, err := client.Get(ctx, nodeInfo.ResourceGroup, nodeInfo.VMname, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := nodeInfo.ApplyAzureVm(&vm.VirtualMachine); err != nil {
+			return nil, err
+		}
+	}
+
+	return nodeInfo, nil
+}
+
+// check if node is in allowed provisioning state
+func (r *AzureK8sAutopilot) nodeTargetCheckProvisionState(ctx context.Context, target *nodeTarget) error {
+	provisioningState, err := r.azureFetchProvisioningState(ctx, *target.info)
 	if err != nil {
 		return err
 	}
+	if provisioningState != nil {
+		target.setInput("provisioningState", *provisioningState)
+	}
+	return r.checkVmProvisionState(provisioningState)
+}
 
-	// fetch instances
-	vmInstance, err := vmssVmClient.Get(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, nodeInfo.VMInstanceID, nil)
-	if err != nil {
+// trigger VMSS action (restart, redeploy, reimage, delete) for multiple instances of one VMSS
+func (r *AzureK8sAutopilot) azureVmssInstancesAction(ctx context.Context, contextLogger *slogger.Logger, vmssInfo k8s.NodeInfo, instanceIDs []*string, action string) (err error) {
+	ctx, span := tracer.Start(ctx, "azure.vmss."+action, trace.WithAttributes(
+		attributeResourceGroup.String(vmssInfo.ResourceGroup),
+		attributeVmss.String(vmssInfo.VMScaleSetName),
+		attributeAction.String(action),
+	))
+	start := time.Now()
+	defer func() {
+		spanError(span, err)
+		span.End()
+		r.auditAzureOperation(ctx, "azure.vmss."+action, action, vmssInfo, start, err)
+	}()
+
+	if vmssInfo.IsVmssFlex {
+		err = r.azureVmssFlexInstancesAction(ctx, contextLogger, vmssInfo, instanceIDs, action)
 		return err
 	}
 
-	// checking vm provision state
-	if err := r.checkVmProvisionState(vmInstance.Properties.ProvisioningState); err != nil {
+	vmssClient, err := armcompute.NewVirtualMachineScaleSetsClient(vmssInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+	if err != nil {
 		return err
 	}
 
-	contextLogger.Info("scheduling action for Azure VMSS instance", slog.String("action", r.Config.Repair.AzureVmssAction), slog.String("providerID", nodeInfo.ProviderId))
-	r.sendNotificationf("trigger automatic repair of K8s node %v (action: %v)", nodeInfo.NodeName, r.Config.Repair.AzureVmssAction)
+	contextLogger.Info("scheduling action for Azure VMSS instances", slog.String("action", action), slog.String("vmss", vmssInfo.VMScaleSetName), slog.Int("instances", len(instanceIDs)))
 
 	// trigger repair
-	switch r.Config.Repair.AzureVmssAction {
+	switch action {
 	case "restart":
 		restartOpts := armcompute.VirtualMachineScaleSetsClientBeginRestartOptions{
 			VMInstanceIDs: &armcompute.VirtualMachineScaleSetVMInstanceIDs{
-				InstanceIDs: []*string{&nodeInfo.VMInstanceID},
+				InstanceIDs: instanceIDs,
 			},
 		}
-		if future, err := vmssClient.BeginRestart(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, &restartOpts); err == nil {
-			if _, futureErr := future.PollUntilDone(r.ctx, nil); futureErr != nil {
-				return futureErr
-			}
-		} else {
+		err = azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachineScaleSetsClientRestartResponse], error) {
+			return vmssClient.BeginRestart(ctx, vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, &restartOpts)
+		})
+		if err != nil {
 			return err
 		}
 	case "redeploy":
 		redeployOpts := armcompute.VirtualMachineScaleSetsClientBeginRedeployOptions{
 			VMInstanceIDs: &armcompute.VirtualMachineScaleSetVMInstanceIDs{
-				InstanceIDs: []*string{&nodeInfo.VMInstanceID},
+				InstanceIDs: instanceIDs,
 			},
 		}
-		if future, err := vmssClient.BeginRedeploy(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, &redeployOpts); err == nil {
-			if _, futureErr := future.PollUntilDone(r.ctx, nil); futureErr != nil {
-				return futureErr
-			}
-		} else {
+		err = azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachineScaleSetsClientRedeployResponse], error) {
+			return vmssClient.BeginRedeploy(ctx, vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, &redeployOpts)
+		})
+		if err != nil {
 			return err
 		}
 	case "reimage":
 		reimageOpts := armcompute.VirtualMachineScaleSetsClientBeginReimageOptions{
 			VMScaleSetReimageInput: &armcompute.VirtualMachineScaleSetReimageParameters{
-				InstanceIDs: []*string{&nodeInfo.VMInstanceID},
+				InstanceIDs: instanceIDs,
 			},
 		}
-		if future, err := vmssClient.BeginReimage(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, &reimageOpts); err == nil {
-			if _, futureErr := future.PollUntilDone(r.ctx, nil); futureErr != nil {
-				return futureErr
-			}
-		} else {
+		err = azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachineScaleSetsClientReimageResponse], error) {
+			return vmssClient.BeginReimage(ctx, vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, &reimageOpts)
+		})
+		if err != nil {
 			return err
 		}
 	case "delete":
 		deleteOpts := armcompute.VirtualMachineScaleSetsClientBeginDeleteInstancesOptions{}
 		vmssInstanceIdsDelete := armcompute.VirtualMachineScaleSetVMInstanceRequiredIDs{
-			InstanceIDs: []*string{&nodeInfo.VMInstanceID},
+			InstanceIDs: instanceIDs,
 		}
 
-		if future, err := vmssClient.BeginDeleteInstances(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, vmssInstanceIdsDelete, &deleteOpts); err == nil {
-			if _, futureErr := future.PollUntilDone(r.ctx, nil); futureErr != nil {
-				return futureErr
-			}
-		} else {
+		err = azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachineScaleSetsClientDeleteInstancesResponse], error) {
+			return vmssClient.BeginDeleteInstances(ctx, vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, vmssInstanceIdsDelete, &deleteOpts)
+		})
+		if err != nil {
 			return err
 		}
 	default:
-		return fmt.Errorf("action %s is not valid", r.Config.Repair.AzureVmssAction)
+		return fmt.Errorf("action %s is not valid", action)
 	}
 
 	return nil
 }
 
-func (r *AzureK8sAutopilot) azureVmRepair(contextLogger *slogger.Logger, nodeInfo k8s.NodeInfo) error {
-	var err error
+func (r *AzureK8sAutopilot) azureVmRepair(ctx context.Context, contextLogger *slogger.Logger, nodeInfo k8s.NodeInfo, action string) (err error) {
+	ctx, span := tracer.Start(ctx, "azure.vm."+action, trace.WithAttributes(
+		attributeResourceGroup.String(nodeInfo.ResourceGroup),
+		attributeNode.String(nodeInfo.NodeName),
+		attributeAction.String(action),
+	))
+	start := time.Now()
+	defer func() {
+		spanError(span, err)
+		span.End()
+		r.auditAzureOperation(ctx, "azure.vm."+action, action, nodeInfo, start, err)
+	}()
 
-	client, err := armcompute.NewVirtualMachinesClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClient.NewArmClientOptions())
+	client, err := armcompute.NewVirtualMachinesClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
 	if err != nil {
 		return err
 	}
 
-	// fetch instances
-	vmInstance, err := client.Get(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMname, nil)
-	if err != nil {
+	contextLogger.Info("scheduling action for Azure VM", slog.String("action", action), slog.String("providerID", nodeInfo.ProviderId))
+
+	switch action {
+	case "restart":
+		err = azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachinesClientRestartResponse], error) {
+			return client.BeginRestart(ctx, nodeInfo.ResourceGroup, nodeInfo.VMname, nil)
+		})
+		if err != nil {
+			return err
+		}
+	case "redeploy":
+		err = azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachinesClientRedeployResponse], error) {
+			return client.BeginRedeploy(ctx, nodeInfo.ResourceGroup, nodeInfo.VMname, nil)
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("action %s is not valid", action)
+	}
+
+	return nil
+}
+
+// trigger VMSS instance update for multiple instances of one VMSS (nodes must be drained before)
+func (r *AzureK8sAutopilot) azureVmssInstancesUpdate(ctx context.Context, contextLogger *slogger.Logger, vmssInfo k8s.NodeInfo, instanceIDs []*string, doReimage bool) (err error) {
+	ctx, span := tracer.Start(ctx, "azure.vmss.update", trace.WithAttributes(
+		attributeResourceGroup.String(vmssInfo.ResourceGroup),
+		attributeVmss.String(vmssInfo.VMScaleSetName),
+		attribute.Bool("autopilot.reimage", doReimage),
+	))
+	start := time.Now()
+	defer func() {
+		spanError(span, err)
+		span.End()
+		action := "update"
+		if doReimage {
+			action = "update+reimage"
+		}
+		r.auditAzureOperation(ctx, "azure.vmss.update", action, vmssInfo, start, err)
+	}()
+
+	if vmssInfo.IsVmssFlex {
+		err = r.azureVmssFlexInstancesUpdate(ctx, contextLogger, vmssInfo, instanceIDs, doReimage)
 		return err
 	}
 
-	// checking vm provision state
-	if err := r.checkVmProvisionState(vmInstance.Properties.ProvisioningState); err != nil {
+	vmssClient, err := armcompute.NewVirtualMachineScaleSetsClient(vmssInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+	if err != nil {
 		return err
 	}
 
-	contextLogger.Info("scheduling action for Azure VM", slog.String("action", r.Config.Repair.AzureVmAction), slog.String("providerID", nodeInfo.ProviderId))
-	r.sendNotificationf("trigger automatic repair of K8s node %v (action: %v)", nodeInfo.NodeName, r.Config.Repair.AzureVmAction)
+	// trigger update call
+	contextLogger.Info("scheduling Azure VMSS instance update", slog.String("vmss", vmssInfo.VMScaleSetName), slog.Int("instances", len(instanceIDs)))
+	vmssInstanceUpdateOpts := armcompute.VirtualMachineScaleSetVMInstanceRequiredIDs{
+		InstanceIDs: instanceIDs,
+	}
+	err = azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachineScaleSetsClientUpdateInstancesResponse], error) {
+		return vmssClient.BeginUpdateInstances(ctx, vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, vmssInstanceUpdateOpts, nil)
+	})
+	if err != nil {
+		return err
+	}
 
-	switch r.Config.Repair.AzureVmAction {
-	case "restart":
-		if future, err := client.BeginRestart(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMname, nil); err == nil {
-			if _, futureErr := future.PollUntilDone(r.ctx, nil); futureErr != nil {
-				return futureErr
-			}
-		} else {
-			return err
+	// trigger reimage call
+	if doReimage {
+		contextLogger.Info("scheduling Azure VMSS instance reimage", slog.String("vmss", vmssInfo.VMScaleSetName), slog.Int("instances", len(instanceIDs)))
+		vmssInstanceReimage := armcompute.VirtualMachineScaleSetsClientBeginRedeployOptions{
+			VMInstanceIDs: &armcompute.VirtualMachineScaleSetVMInstanceIDs{
+				InstanceIDs: instanceIDs,
+			},
 		}
-	case "redeploy":
-		if future, err := client.BeginRedeploy(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMname, nil); err == nil {
-			if _, futureErr := future.PollUntilDone(r.ctx, nil); futureErr != nil {
-				return futureErr
-			}
-		} else {
+		err = azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachineScaleSetsClientRedeployResponse], error) {
+			return vmssClient.BeginRedeploy(ctx, vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, &vmssInstanceReimage)
+		})
+		if err != nil {
 			return err
 		}
-	default:
-		return fmt.Errorf("action %s is not valid", r.Config.Repair.AzureVmssAction)
 	}
 
 	return nil
 }
 
-// trigger VMSS instance update
-func (r *AzureK8sAutopilot) azureVmssInstanceUpdate(contextLogger *slogger.Logger, node *k8s.Node, nodeInfo k8s.NodeInfo, doReimage bool) error {
-	var err error
-
-	vmssClient, err := armcompute.NewVirtualMachineScaleSetsClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClient.NewArmClientOptions())
+// trigger action (restart, redeploy, reimage, delete) for multiple VMSS Flex instances, the VMSS instance APIs
+// don't support VMSS Flex so the action is triggered for each VM (instance IDs are VM names)
+func (r *AzureK8sAutopilot) azureVmssFlexInstancesAction(ctx context.Context, contextLogger *slogger.Logger, vmssInfo k8s.NodeInfo, instanceIDs []*string, action string) error {
+	client, err := armcompute.NewVirtualMachinesClient(vmssInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
 	if err != nil {
 		return err
 	}
 
-	vmssVmClient, err := armcompute.NewVirtualMachineScaleSetVMsClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClient.NewArmClientOptions())
+	switch action {
+	case "restart", "redeploy", "reimage", "delete":
+	default:
+		return fmt.Errorf("action %s is not valid", action)
+	}
+
+	contextLogger.Info("scheduling action for Azure VMSS Flex instances", slog.String("action", action), slog.String("vmss", vmssInfo.VMScaleSetName), slog.Int("instances", len(instanceIDs)))
+
+	return azureVmssFlexForEachInstance(instanceIDs, func(vmName string) error {
+		switch action {
+		case "restart":
+			return azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachinesClientRestartResponse], error) {
+				return client.BeginRestart(ctx, vmssInfo.ResourceGroup, vmName, nil)
+			})
+		case "redeploy":
+			return azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachinesClientRedeployResponse], error) {
+				return client.BeginRedeploy(ctx, vmssInfo.ResourceGroup, vmName, nil)
+			})
+		case "reimage":
+			return azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachinesClientReimageResponse], error) {
+				return client.BeginReimage(ctx, vmssInfo.ResourceGroup, vmName, nil)
+			})
+		default:
+			return azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachinesClientDeleteResponse], error) {
+				return client.BeginDelete(ctx, vmssInfo.ResourceGroup, vmName, nil)
+			})
+		}
+	})
+}
+
+// update multiple VMSS Flex instances to the VM size of the scale set model and reimage them if requested
+// (there is no instance update API for VMSS Flex, other model changes are only applied to new instances)
+func (r *AzureK8sAutopilot) azureVmssFlexInstancesUpdate(ctx context.Context, contextLogger *slogger.Logger, vmssInfo k8s.NodeInfo, instanceIDs []*string, doReimage bool) error {
+	vmssClient, err := armcompute.NewVirtualMachineScaleSetsClient(vmssInfo.VMScaleSetSubscription, r.azureClient.GetCred(), r.azureClientOptions())
 	if err != nil {
 		return err
 	}
 
-	// fetch instances
-	vmInstance, err := vmssVmClient.Get(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, nodeInfo.VMInstanceID, nil)
+	client, err := armcompute.NewVirtualMachinesClient(vmssInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
 	if err != nil {
 		return err
 	}
 
-	// checking vm provision state
-	if err := r.checkVmProvisionState(vmInstance.Properties.ProvisioningState); err != nil {
+	vmss, err := vmssClient.Get(ctx, vmssInfo.VMScaleSetResourceGroup, vmssInfo.VMScaleSetName, nil)
+	if err != nil {
+		r.azureHandleError(contextLogger, "azure", err)
 		return err
 	}
 
-	r.sendNotificationf("trigger automatic update of K8s node %v", nodeInfo.NodeName)
+	contextLogger.Info("scheduling Azure VMSS Flex instance update", slog.String("vmss", vmssInfo.VMScaleSetName), slog.Int("instances", len(instanceIDs)), slog.Bool("reimage", doReimage))
+
+	return azureVmssFlexForEachInstance(instanceIDs, func(vmName string) error {
+		if vmss.SKU != nil && vmss.SKU.Name != nil {
+			vmSize := armcompute.VirtualMachineSizeTypes(*vmss.SKU.Name)
+			vmUpdate := armcompute.VirtualMachineUpdate{
+				Properties: &armcompute.VirtualMachineProperties{
+					HardwareProfile: &armcompute.HardwareProfile{
+						VMSize: &vmSize,
+					},
+				},
+			}
+			err := azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachinesClientUpdateResponse], error) {
+				return client.BeginUpdate(ctx, vmssInfo.ResourceGroup, vmName, vmUpdate, nil)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if doReimage {
+			return azureBeginAndPoll(ctx, r, contextLogger, func() (*runtime.Poller[armcompute.VirtualMachinesClientReimageResponse], error) {
+				return client.BeginReimage(ctx, vmssInfo.ResourceGroup, vmName, nil)
+			})
+		}
+
+		return nil
+	})
+}
 
-	// drain node
-	if err := r.k8sDrainNode(contextLogger, node); err != nil {
-		return fmt.Errorf("node %s failed to drain: %w", node.Name, err)
+// run Azure operation for each VMSS Flex instance in parallel (like the batched VMSS instance APIs), errors are joined
+func azureVmssFlexForEachInstance(instanceIDs []*string, callback func(vmName string) error) error {
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		errList []error
+	)
+	for _, instanceID := range instanceIDs {
+		vmName := *instanceID
+		wg.Go(func() {
+			if err := callback(vmName); err != nil {
+				lock.Lock()
+				errList = append(errList, fmt.Errorf("VMSS Flex instance %s: %w", vmName, err))
+				lock.Unlock()
+			}
+		})
 	}
+	wg.Wait()
 
-	// trigger update call
-	contextLogger.Info("scheduling Azure VMSS instance update")
-	vmssInstanceUpdateOpts := armcompute.VirtualMachineScaleSetVMInstanceRequiredIDs{
-		InstanceIDs: []*string{vmInstance.InstanceID},
+	return errors.Join(errList...)
+}
+
+// check outcome of batched action for each VMSS instance
+func (r *AzureK8sAutopilot) azureVmssInstancesOutcome(ctx context.Context, vmssInfo k8s.NodeInfo, instanceIDs []*string, checkLatestModel bool) map[string]error {
+	if vmssInfo.IsVmssFlex {
+		return r.azureVmssFlexInstancesOutcome(ctx, vmssInfo, instanceIDs, checkLatestModel)
 	}
-	if future, err := vmssClient.BeginUpdateInstances(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, vmssInstanceUpdateOpts, nil); err == nil {
-		// wait for update
-		if _, futureErr := future.PollUntilDone(r.ctx, nil); futureErr != nil {
-			return futureErr
+
+	result := map[string]error{}
+
+	vmssVmClient, err := armcompute.NewVirtualMachineScaleSetVMsClient(vmssInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+	if err != nil {
+		for _, instanceID := range instanceIDs {
+			result[*instanceID] = err
 		}
-	} else {
-		return err
+		return result
 	}
 
-	// trigger reimage call
-	if doReimage {
-		contextLogger.Info("scheduling Azure VMSS instance reimage")
-		vmssInstanceReimage := armcompute.VirtualMachineScaleSetsClientBeginRedeployOptions{
-			VMInstanceIDs: &armcompute.VirtualMachineScaleSetVMInstanceIDs{
-				InstanceIDs: []*string{vmInstance.InstanceID},
-			},
+	for _, instanceID := range instanceIDs {
+		vmInstance, err := vmssVmClient.Get(ctx, vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, *instanceID, nil)
+		switch {
+		case err != nil:
+			result[*instanceID] = err
+		case vmInstance.Properties == nil:
+			result[*instanceID] = fmt.Errorf("VMSS instance %s has no properties", *instanceID)
+		case vmInstance.Properties.ProvisioningState != nil && strings.EqualFold(*vmInstance.Properties.ProvisioningState, string(armcompute.ExecutionStateFailed)):
+			result[*instanceID] = fmt.Errorf("VMSS instance %s is in ProvisioningState \"%s\"", *instanceID, *vmInstance.Properties.ProvisioningState)
+		case checkLatestModel && vmInstance.Properties.LatestModelApplied != nil && !*vmInstance.Properties.LatestModelApplied:
+			result[*instanceID] = fmt.Errorf("VMSS instance %s is not running latest model", *instanceID)
+		default:
+			result[*instanceID] = nil
+		}
+	}
+
+	return result
+}
+
+// check outcome of batched action for each VMSS Flex instance (instance IDs are VM names)
+func (r *AzureK8sAutopilot) azureVmssFlexInstancesOutcome(ctx context.Context, vmssInfo k8s.NodeInfo, instanceIDs []*string, checkLatestModel bool) map[string]error {
+	result := map[string]error{}
+
+	setError := func(err error) map[string]error {
+		for _, instanceID := range instanceIDs {
+			result[*instanceID] = err
+		}
+		return result
+	}
+
+	vmClient, err := armcompute.NewVirtualMachinesClient(vmssInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+	if err != nil {
+		return setError(err)
+	}
+
+	var vmssModel *armcompute.VirtualMachineScaleSet
+	if checkLatestModel {
+		vmssClient, err := armcompute.NewVirtualMachineScaleSetsClient(vmssInfo.VMScaleSetSubscription, r.azureClient.GetCred(), r.azureClientOptions())
+		if err != nil {
+			return setError(err)
+		}
+
+		vmss, err := vmssClient.Get(ctx, vmssInfo.VMScaleSetResourceGroup, vmssInfo.VMScaleSetName, nil)
+		if err != nil {
+			return setError(err)
+		}
+		vmssModel = &vmss.VirtualMachineScaleSet
+	}
+
+	for _, instanceID := range instanceIDs {
+		vm, err := vmClient.Get(ctx, vmssInfo.ResourceGroup, *instanceID, nil)
+		if err != nil {
+			result[*instanceID] = err
+			continue
+		}
+
+		var latestModelApplied *bool
+		if vmssModel != nil && vm.Properties != nil {
+			latestModelApplied = k8s.VmssFlexLatestModelApplied(&vm.VirtualMachine, vmssModel)
+		}
+
+		switch {
+		case vm.Properties == nil:
+			result[*instanceID] = fmt.Errorf("VMSS Flex instance %s has no properties", *instanceID)
+		case vm.Properties.ProvisioningState != nil && strings.EqualFold(*vm.Properties.ProvisioningState, string(armcompute.ExecutionStateFailed)):
+			result[*instanceID] = fmt.Errorf("VMSS Flex instance %s is in ProvisioningState \"%s\"", *instanceID, *vm.Properties.ProvisioningState)
+		case latestModelApplied != nil && !*latestModelApplied:
+			result[*instanceID] = fmt.Errorf("VMSS Flex instance %s is not running latest model", *instanceID)
+		default:
+			result[*instanceID] = nil
+		}
+	}
+
+	return result
+}
+
+// names of data disks attached to Azure VM (fetched from Azure API, not from inventory cache)
+func (r *AzureK8sAutopilot) azureNodeDataDisks(ctx context.Context, nodeInfo k8s.NodeInfo) ([]string, error) {
+	var storageProfile *armcompute.StorageProfile
+
+	if nodeInfo.IsVmss && !nodeInfo.IsVmssFlex {
+		vmssVmClient, err := armcompute.NewVirtualMachineScaleSetVMsClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		vmInstance, err := vmssVmClient.Get(ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, nodeInfo.VMInstanceID, nil)
+		if err != nil {
+			return nil, err
+		}
+		if vmInstance.Properties != nil {
+			storageProfile = vmInstance.Properties.StorageProfile
+		}
+	} else {
+		client, err := armcompute.NewVirtualMachinesClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		vmInstance, err := client.Get(ctx, nodeInfo.ResourceGroup, nodeInfo.VMname, nil)
+		if err != nil {
+			return nil, err
 		}
-		if future, err := vmssClient.BeginRedeploy(r.ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, &vmssInstanceReimage); err == nil {
-			// wait for update
-			if _, futureErr := future.PollUntilDone(r.ctx, nil); futureErr != nil {
-				return futureErr
+		if vmInstance.Properties != nil {
+			storageProfile = vmInstance.Properties.StorageProfile
+		}
+	}
+
+	disks := []string{}
+	if storageProfile != nil {
+		for _, disk := range storageProfile.DataDisks {
+			if disk.Name != nil {
+				disks = append(disks, *disk.Name)
 			}
-		} else {
-			return err
 		}
 	}
 
-	return nil
+	return disks, nil
+}
+
+// fetch current provisioning state from Azure API (inventory cache might be outdated before actions)
+func (r *AzureK8sAutopilot) azureFetchProvisioningState(ctx context.Context, nodeInfo k8s.NodeInfo) (*string, error) {
+	// VMSS Flex instances are fetched as VM
+	if nodeInfo.IsVmss && !nodeInfo.IsVmssFlex {
+		vmssVmClient, err := armcompute.NewVirtualMachineScaleSetVMsClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		vmInstance, err := vmssVmClient.Get(ctx, nodeInfo.ResourceGroup, nodeInfo.VMScaleSetName, nodeInfo.VMInstanceID, nil)
+		if err != nil {
+			return nil, err
+		}
+		return vmInstance.Properties.ProvisioningState, nil
+	}
+
+	client, err := armcompute.NewVirtualMachinesClient(nodeInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	vmInstance, err := client.Get(ctx, nodeInfo.ResourceGroup, nodeInfo.VMname, nil)
+	if err != nil {
+		return nil, err
+	}
+	return vmInstance.Properties.ProvisioningState, nil
 }
 
 // check current VM provision state if change is allowed
@@ -225,3 +606,33 @@ func (r *AzureK8sAutopilot) checkVmProvisionState(provisioningState *string) (er
 
 	return
 }
+
+// start Azure operation and poll it until done, only the Begin* call is retried for transient errors
+// (polling errors are not retried as the operation is already running and must not be triggered again)
+func azureBeginAndPoll[T any](ctx context.Context, r *AzureK8sAutopilot, contextLogger *slogger.Logger, begin func() (*runtime.Poller[T], error)) error {
+	var future *runtime.Poller[T]
+	err := r.azureRetry(ctx, contextLogger, "azure", func() (err error) {
+		future, err = begin()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = azurePollUntilDone(ctx, future, r.Config.Azure.OperationTimeout)
+	r.azureHandleError(contextLogger, "azure", err)
+	return err
+}
+
+// wait for Azure long running operation, timeout of zero means infinite
+func azurePollUntilDone[T any](ctx context.Context, future *runtime.Poller[T], timeout time.Duration) (T, error) {
+	ctx, span := tracer.Start(ctx, "azure.poll")
+	defer span.End()
+
+	ctx, cancel := contextWithOptionalTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := future.PollUntilDone(ctx, nil)
+	spanError(span, err)
+	return result, err
+}
@@ -0,0 +1,66 @@
+package autopilot
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+const (
+	AzurePolicyHandlingSkip   = "skip"
+	AzurePolicyHandlingWarn   = "warn"
+	AzurePolicyHandlingIgnore = "ignore"
+
+	AzurePolicyReasonProtectFromScaleSetActions = "protectFromScaleSetActions"
+	AzurePolicyReasonProtectFromScaleIn         = "protectFromScaleIn"
+	AzurePolicyReasonUpgradePolicy              = "upgradePolicy"
+)
+
+// checks VMSS instance protection policy, returns false if node should be skipped
+func (r *AzureK8sAutopilot) azurePolicyCheckProtection(contextLogger *slogger.Logger, task string, node *k8s.Node, action, handling string) bool {
+	protectFromScaleIn, protectFromScaleSetActions := node.AzureProtectionPolicy()
+	return r.azurePolicyCheckProtectionPolicy(contextLogger, task, node.Name, protectFromScaleIn, protectFromScaleSetActions, action, handling)
+}
+
+// checks VMSS instance protection policy of node or instance (name), returns false if it should be skipped
+func (r *AzureK8sAutopilot) azurePolicyCheckProtectionPolicy(contextLogger *slogger.Logger, task, name string, protectFromScaleIn, protectFromScaleSetActions bool, action, handling string) bool {
+	switch {
+	case protectFromScaleSetActions:
+		return r.azurePolicyHandle(contextLogger, task, name, action, AzurePolicyReasonProtectFromScaleSetActions, handling)
+	case protectFromScaleIn && action == "delete":
+		return r.azurePolicyHandle(contextLogger, task, name, action, AzurePolicyReasonProtectFromScaleIn, handling)
+	}
+
+	return true
+}
+
+// checks scale set upgrade policy (only Manual scale sets are updated by autopilot), returns false if node should be skipped
+func (r *AzureK8sAutopilot) azurePolicyCheckUpgradePolicy(contextLogger *slogger.Logger, task string, node *k8s.Node, action, handling string) bool {
+	mode := node.AzureUpgradePolicyMode()
+	if mode == "" || strings.EqualFold(mode, string(armcompute.UpgradeModeManual)) {
+		return true
+	}
+
+	return r.azurePolicyHandle(contextLogger.With(slog.String("upgradePolicy", mode)), task, node.Name, action, AzurePolicyReasonUpgradePolicy, handling)
+}
+
+func (r *AzureK8sAutopilot) azurePolicyHandle(contextLogger *slogger.Logger, task, name, action, reason, handling string) bool {
+	contextLogger = contextLogger.With(slog.String("node", name), slog.String("policy", reason))
+
+	switch handling {
+	case AzurePolicyHandlingSkip:
+		contextLogger.Info("skipping node because of Azure policy")
+		if r.metricsNodeSkipped(task, name, action, reason) {
+			r.prometheus.general.skippedNodes.WithLabelValues(task, reason).Inc()
+		}
+		return false
+	case AzurePolicyHandlingWarn:
+		contextLogger.Warn("Azure policy is set for node, continuing anyway")
+	}
+
+	return true
+}
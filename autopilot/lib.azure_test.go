@@ -0,0 +1,100 @@
+package autopilot
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+func testNode(name string) *k8s.Node {
+	return &k8s.Node{Node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}}
+}
+
+func TestGroupNodeTargets(t *testing.T) {
+	vmss := func(name, scaleSet, action string) *nodeTarget {
+		return &nodeTarget{
+			node:   testNode(name),
+			info:   &k8s.NodeInfo{Subscription: "sub", ResourceGroup: "rg", IsVmss: true, VMScaleSetName: scaleSet, VMScaleSetSubscription: "sub", VMScaleSetResourceGroup: "rg", VMInstanceID: name},
+			action: action,
+		}
+	}
+	vmssFlex := func(name, resourceGroup string) *nodeTarget {
+		return &nodeTarget{
+			node:   testNode(name),
+			info:   &k8s.NodeInfo{Subscription: "sub", ResourceGroup: resourceGroup, IsVmss: true, IsVmssFlex: true, VMScaleSetName: "flex1", VMScaleSetSubscription: "sub", VMScaleSetResourceGroup: "rg-vmss", VMInstanceID: name, VMname: name},
+			action: "reimage",
+		}
+	}
+	vm := func(name string) *nodeTarget {
+		return &nodeTarget{
+			node: testNode(name),
+			info: &k8s.NodeInfo{Subscription: "sub", ResourceGroup: "rg", VMname: name, ProviderId: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/" + name},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		targets []*nodeTarget
+		expect  [][]string
+	}{
+		{
+			name:    "empty",
+			targets: nil,
+			expect:  nil,
+		},
+		{
+			name:    "same scale set",
+			targets: []*nodeTarget{vmss("a", "pool1", "reimage"), vmss("b", "pool1", "reimage")},
+			expect:  [][]string{{"a", "b"}},
+		},
+		{
+			name:    "scale set names are case insensitive",
+			targets: []*nodeTarget{vmss("a", "pool1", "reimage"), vmss("b", "POOL1", "reimage")},
+			expect:  [][]string{{"a", "b"}},
+		},
+		{
+			name:    "different scale sets keep order",
+			targets: []*nodeTarget{vmss("a", "pool1", "reimage"), vmss("b", "pool2", "reimage"), vmss("c", "pool1", "reimage")},
+			expect:  [][]string{{"a", "c"}, {"b"}},
+		},
+		{
+			name:    "different actions",
+			targets: []*nodeTarget{vmss("a", "pool1", "reimage"), vmss("b", "pool1", "restart")},
+			expect:  [][]string{{"a"}, {"b"}},
+		},
+		{
+			name:    "vmss flex instances are batched by resource group",
+			targets: []*nodeTarget{vmssFlex("a", "rg1"), vmssFlex("b", "rg2"), vmssFlex("c", "rg1")},
+			expect:  [][]string{{"a", "c"}, {"b"}},
+		},
+		{
+			name:    "vms are not batched",
+			targets: []*nodeTarget{vm("a"), vm("b"), vmss("c", "pool1", "reimage")},
+			expect:  [][]string{{"a"}, {"b"}, {"c"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groups := groupNodeTargets(test.targets)
+			if len(groups) != len(test.expect) {
+				t.Fatalf("expected %v groups, got %v", len(test.expect), len(groups))
+			}
+
+			for i, group := range groups {
+				names := nodeTargetNames(group)
+				if len(names) != len(test.expect[i]) {
+					t.Fatalf("group %v: expected %v, got %v", i, test.expect[i], names)
+				}
+				for j := range names {
+					if names[j] != test.expect[i][j] {
+						t.Errorf("group %v: expected %v, got %v", i, test.expect[i], names)
+					}
+				}
+			}
+		})
+	}
+}
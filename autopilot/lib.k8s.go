@@ -1,18 +1,64 @@
 package autopilot
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/copier"
 	"github.com/webdevops/go-common/log/slogger"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	k8scache "k8s.io/client-go/tools/cache"
 
 	"github.com/webdevopos/azure-k8s-autopilot/config"
 	"github.com/webdevopos/azure-k8s-autopilot/k8s"
 )
 
+const (
+	volumeDetachPollInterval = 5 * time.Second
+
+	// VolumeAttachment informer index by node name
+	volumeAttachmentNodeIndex = "nodeName"
+)
+
+// watch VolumeAttachments (indexed by node) for volume detach checks after drain
+func (r *AzureK8sAutopilot) initVolumeAttachmentInformer() {
+	if r.Config.Drain.VolumeDetachTimeout <= 0 {
+		return
+	}
+
+	factory := informers.NewSharedInformerFactory(r.k8sClient, 0)
+	informer := factory.Storage().V1().VolumeAttachments().Informer()
+	err := informer.AddIndexers(k8scache.Indexers{
+		volumeAttachmentNodeIndex: func(obj interface{}) ([]string, error) {
+			if volumeAttachment, ok := obj.(*storagev1.VolumeAttachment); ok {
+				return []string{volumeAttachment.Spec.NodeName}, nil
+			}
+			return []string{}, nil
+		},
+	})
+	if err != nil {
+		r.Logger.Panic(err.Error())
+	}
+
+	r.volumeAttachmentInformer = informer
+	factory.Start(r.ctx.Done())
+}
+
 // trigger drain node
-func (r *AzureK8sAutopilot) k8sDrainNode(logger *slogger.Logger, node *k8s.Node) error {
+func (r *AzureK8sAutopilot) k8sDrainNode(ctx context.Context, logger *slogger.Logger, node *k8s.Node) (err error) {
+	ctx, span := tracer.Start(ctx, "k8s.drain", trace.WithAttributes(attributeNode.String(node.Name)))
+	defer func() {
+		spanError(span, err)
+		span.End()
+	}()
+
 	nodeLogger := logger.With(slog.String("node", node.Name))
 
 	if !r.Config.Drain.Enable {
@@ -20,6 +66,11 @@ func (r *AzureK8sAutopilot) k8sDrainNode(logger *slogger.Logger, node *k8s.Node)
 		return nil
 	}
 
+	start := time.Now()
+	defer func() {
+		r.auditNode(ctx, AuditOperationNodeDrain, node, start, r.Config.Drain.DryRun, err)
+	}()
+
 	var drainOpts config.OptsDrain
 	if copyErr := copier.Copy(&drainOpts, &r.Config.Drain); copyErr != nil {
 		return copyErr
@@ -30,7 +81,7 @@ func (r *AzureK8sAutopilot) k8sDrainNode(logger *slogger.Logger, node *k8s.Node)
 	kubectl.Conf = drainOpts
 	kubectl.SetNode(node.Name)
 	kubectl.SetLogger(nodeLogger)
-	err := kubectl.NodeDrain()
+	err = kubectl.NodeDrain(ctx)
 
 	// retry drain if first one failed
 	if err != nil && r.Config.Drain.RetryWithoutEviction {
@@ -40,7 +91,7 @@ func (r *AzureK8sAutopilot) k8sDrainNode(logger *slogger.Logger, node *k8s.Node)
 		kubectl.Conf = drainOpts
 		kubectl.SetNode(node.Name)
 		kubectl.SetLogger(nodeLogger)
-		err = kubectl.NodeDrain()
+		err = kubectl.NodeDrain(ctx)
 	}
 
 	// ignore error
@@ -50,18 +101,214 @@ func (r *AzureK8sAutopilot) k8sDrainNode(logger *slogger.Logger, node *k8s.Node)
 	}
 
 	if err == nil {
-		nodeLogger.Info("waiting after drain", slog.Duration("waitTime", r.Config.Drain.WaitAfter))
-		time.Sleep(r.Config.Drain.WaitAfter)
+		// nothing is evicted in dry run, volumes stay attached
+		if r.Config.Drain.VolumeDetachTimeout > 0 && !r.Config.Drain.DryRun {
+			err = r.k8sWaitVolumeDetach(ctx, nodeLogger, node)
+			if err != nil && r.Config.Drain.IgnoreFailure {
+				nodeLogger.Warn("volumes not detached after drain, but ignoring error", slog.Any("error", err))
+				err = nil
+			}
+		} else {
+			nodeLogger.Info("waiting after drain", slog.Duration("waitTime", r.Config.Drain.WaitAfter))
+			select {
+			case <-time.After(r.Config.Drain.WaitAfter):
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+	}
+
+	return err
+}
+
+// drain node before repair, only tried if kubelet is still reachable
+// returns true if drain was started (node is cordoned) and the drain error, repair continues on failed drains
+func (r *AzureK8sAutopilot) k8sRepairDrainNode(ctx context.Context, logger *slogger.Logger, node *k8s.Node) (cordoned bool, err error) {
+	ctx, span := tracer.Start(ctx, "k8s.drain", trace.WithAttributes(attributeNode.String(node.Name)))
+	defer func() {
+		spanError(span, err)
+		span.End()
+	}()
+
+	nodeLogger := logger.With(slog.String("node", node.Name))
+
+	leaseAge, leaseErr := r.k8sKubeletLeaseAge(ctx, node)
+	if leaseErr != nil {
+		nodeLogger.Warn("unable to fetch kubelet lease, not draining node", slog.Any("error", leaseErr))
+		return false, nil
+	}
+
+	if leaseAge > r.Config.Repair.Drain.KubeletLeaseMaxAge {
+		nodeLogger.Info("kubelet is not reachable, not draining node", slog.Duration("leaseAge", leaseAge))
+		return false, nil
+	}
+
+	drainOpts := config.OptsDrain{
+		KubectlPath:        r.Config.Drain.KubectlPath,
+		DryRun:             r.Config.Drain.DryRun,
+		Timeout:            r.Config.Repair.Drain.Timeout,
+		DeleteEmptydirData: r.Config.Repair.Drain.DeleteEmptydirData,
+		Force:              r.Config.Repair.Drain.Force,
+		GracePeriod:        r.Config.Repair.Drain.GracePeriod,
+		IgnoreDaemonsets:   r.Config.Repair.Drain.IgnoreDaemonsets,
+		PodSelector:        r.Config.Repair.Drain.PodSelector,
+	}
+
+	kubectl := k8s.Kubectl{}
+	kubectl.Conf = drainOpts
+	kubectl.SetNode(node.Name)
+	kubectl.SetLogger(nodeLogger)
+	start := time.Now()
+	err = kubectl.NodeDrain(ctx)
+	r.auditNode(ctx, AuditOperationNodeDrain, node, start, drainOpts.DryRun, err)
+	if err != nil {
+		nodeLogger.Warn("failed to drain node, continuing with repair", slog.Any("error", err))
+	}
+
+	return true, err
+}
+
+// age of kubelet node lease (time since last renew)
+func (r *AzureK8sAutopilot) k8sKubeletLeaseAge(ctx context.Context, node *k8s.Node) (time.Duration, error) {
+	lease, err := r.k8sClient.CoordinationV1().Leases(corev1.NamespaceNodeLease).Get(ctx, node.Name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	if lease.Spec.RenewTime == nil {
+		return 0, fmt.Errorf("kubelet lease of node %s was never renewed", node.Name)
+	}
+
+	return time.Since(lease.Spec.RenewTime.Time), nil
+}
+
+// wait until all volumes are detached from node (VolumeAttachments and optionally Azure data disks)
+func (r *AzureK8sAutopilot) k8sWaitVolumeDetach(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) error {
+	contextLogger.Info("waiting for volume detach", slog.Duration("timeout", r.Config.Drain.VolumeDetachTimeout))
+
+	ctx, cancel := context.WithTimeout(ctx, r.Config.Drain.VolumeDetachTimeout)
+	defer cancel()
+
+	var nodeInfo *k8s.NodeInfo
+	if r.Config.Drain.VolumeDetachAzure {
+		var err error
+		if nodeInfo, err = k8s.ExtractNodeInfo(node); err != nil {
+			return err
+		}
+	}
+
+	for {
+		volumes, err := r.k8sNodeVolumeAttachments(node)
+		if err == nil && len(volumes) == 0 && nodeInfo != nil {
+			volumes, err = r.azureNodeDataDisks(ctx, *nodeInfo)
+		}
+
+		if err == nil && len(volumes) == 0 {
+			contextLogger.Info("all volumes detached")
+			return nil
+		}
+
+		if err != nil {
+			contextLogger.Warn("unable to check volume attachments", slog.Any("error", err))
+		} else {
+			contextLogger.Debug("waiting for volume detach", slog.Any("volumes", volumes))
+		}
+
+		select {
+		case <-time.After(volumeDetachPollInterval):
+		case <-ctx.Done():
+			if len(volumes) > 0 {
+				return fmt.Errorf("volumes still attached to node %s after %s: %s", node.Name, r.Config.Drain.VolumeDetachTimeout.String(), strings.Join(volumes, ", "))
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// names of volumes (PV name or VolumeAttachment name) attached to node (from VolumeAttachment informer)
+func (r *AzureK8sAutopilot) k8sNodeVolumeAttachments(node *k8s.Node) ([]string, error) {
+	if !r.volumeAttachmentInformer.HasSynced() {
+		return nil, fmt.Errorf("VolumeAttachment informer is not synced yet")
+	}
+
+	volumeAttachmentList, err := r.volumeAttachmentInformer.GetIndexer().ByIndex(volumeAttachmentNodeIndex, node.Name)
+	if err != nil {
+		return nil, err
 	}
 
+	volumes := []string{}
+	for _, obj := range volumeAttachmentList {
+		volumeAttachment, ok := obj.(*storagev1.VolumeAttachment)
+		if !ok {
+			continue
+		}
+
+		if volumeAttachment.Spec.Source.PersistentVolumeName != nil {
+			volumes = append(volumes, *volumeAttachment.Spec.Source.PersistentVolumeName)
+		} else {
+			volumes = append(volumes, volumeAttachment.Name)
+		}
+	}
+
+	return volumes, nil
+}
+
+// trigger cordon node
+func (r *AzureK8sAutopilot) k8sCordonNode(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) error {
+	ctx, span := tracer.Start(ctx, "k8s.cordon", trace.WithAttributes(attributeNode.String(node.Name)))
+	defer span.End()
+
+	kubectl := k8s.Kubectl{}
+	kubectl.Conf = r.Config.Drain
+	kubectl.SetNode(node.Name)
+	kubectl.SetLogger(contextLogger)
+	start := time.Now()
+	err := kubectl.NodeCordon(ctx)
+	spanError(span, err)
+	r.auditNode(ctx, AuditOperationNodeCordon, node, start, r.Config.Drain.DryRun, err)
 	return err
 }
 
 // trigger uncordon node
-func (r *AzureK8sAutopilot) k8sUncordonNode(contextLogger *slogger.Logger, node *k8s.Node) error {
+func (r *AzureK8sAutopilot) k8sUncordonNode(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) error {
+	ctx, span := tracer.Start(ctx, "k8s.uncordon", trace.WithAttributes(attributeNode.String(node.Name)))
+	defer span.End()
+
 	kubectl := k8s.Kubectl{}
 	kubectl.Conf = r.Config.Drain
 	kubectl.SetNode(node.Name)
 	kubectl.SetLogger(contextLogger)
-	return kubectl.NodeUncordon()
+	start := time.Now()
+	err := kubectl.NodeUncordon(ctx)
+	spanError(span, err)
+	r.auditNode(ctx, AuditOperationNodeUncordon, node, start, r.Config.Drain.DryRun, err)
+	return err
+}
+
+// create K8s event for node
+func (r *AzureK8sAutopilot) k8sNodeEvent(ctx context.Context, node *k8s.Node, eventType, reason, message string) error {
+	now := metav1.Now()
+	event := corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s.", node.Name),
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Node",
+			APIVersion: "v1",
+			Name:       node.Name,
+			UID:        node.UID,
+		},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Source: corev1.EventSource{
+			Component: "azure-k8s-autopilot",
+		},
+	}
+
+	_, err := r.k8sClient.CoreV1().Events(metav1.NamespaceDefault).Create(ctx, &event, metav1.CreateOptions{})
+	return err
 }
@@ -0,0 +1,141 @@
+package autopilot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsResultSuccess = "success"
+	metricsResultFailure = "failure"
+	metricsResultSkipped = "skipped"
+)
+
+func (r *AzureK8sAutopilot) initMetricsOperation() {
+	r.prometheus.operation.azureDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "autopilot_azure_operation_duration_seconds",
+			Help:    "azure_k8s_autopilot duration of Azure operations (including polling and retries) per node",
+			Buckets: []float64{10, 30, 60, 120, 300, 600, 900, 1800, 3600},
+		},
+		[]string{"task", "action", "pool", "result"},
+	)
+	prometheus.MustRegister(r.prometheus.operation.azureDuration)
+
+	r.prometheus.operation.drainDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "autopilot_drain_duration_seconds",
+			Help:    "azure_k8s_autopilot duration of node drains",
+			Buckets: []float64{5, 15, 30, 60, 120, 300, 600, 1800},
+		},
+		[]string{"task", "action", "pool", "result"},
+	)
+	prometheus.MustRegister(r.prometheus.operation.drainDuration)
+
+	r.prometheus.operation.maintenanceDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "autopilot_node_maintenance_duration_seconds",
+			Help:    "azure_k8s_autopilot end-to-end duration of node repairs and updates (drain, Azure operation, verification, uncordon)",
+			Buckets: []float64{60, 120, 300, 600, 900, 1800, 3600, 7200},
+		},
+		[]string{"task", "action", "pool", "result"},
+	)
+	prometheus.MustRegister(r.prometheus.operation.maintenanceDuration)
+
+	r.prometheus.operation.actions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_node_actions",
+			Help: "azure_k8s_autopilot node action counter by outcome (success, failure, skipped with reason)",
+		},
+		[]string{"task", "action", "outcome", "reason"},
+	)
+	prometheus.MustRegister(r.prometheus.operation.actions)
+}
+
+// VMSS name of node target, empty for VMs
+func nodeTargetPool(target *nodeTarget) string {
+	if target.info == nil {
+		return ""
+	}
+	return target.info.VMScaleSetName
+}
+
+func metricsResult(err error) string {
+	if err != nil {
+		return metricsResultFailure
+	}
+	return metricsResultSuccess
+}
+
+func (r *AzureK8sAutopilot) metricsAzureOperation(task, action string, target *nodeTarget, start time.Time, err error) {
+	r.prometheus.operation.azureDuration.WithLabelValues(task, action, nodeTargetPool(target), metricsResult(err)).Observe(time.Since(start).Seconds())
+}
+
+func (r *AzureK8sAutopilot) metricsDrain(task, action string, target *nodeTarget, start time.Time, result string) {
+	r.prometheus.operation.drainDuration.WithLabelValues(task, action, nodeTargetPool(target), result).Observe(time.Since(start).Seconds())
+}
+
+// outcome and end-to-end duration of node repair or update
+func (r *AzureK8sAutopilot) metricsNodeFinished(task, action string, target *nodeTarget, err error) {
+	result := metricsResult(err)
+	r.prometheus.operation.actions.WithLabelValues(task, action, result, "").Inc()
+	r.metricsNodeSkipReset(task, target.node.Name)
+
+	// node failed before maintenance was started (eg. provisioning state check)
+	if !target.started.IsZero() {
+		r.prometheus.operation.maintenanceDuration.WithLabelValues(task, action, nodeTargetPool(target), result).Observe(time.Since(target.started).Seconds())
+	}
+}
+
+// counts skipped node once per reason (not on every run while the node stays skipped), returns false if already counted
+func (r *AzureK8sAutopilot) metricsNodeSkipped(task, name, action, reason string) bool {
+	key := task + "/" + name
+
+	r.skipped.lock.Lock()
+	defer r.skipped.lock.Unlock()
+
+	if r.skipped.reasons[key] == reason {
+		return false
+	}
+	r.skipped.reasons[key] = reason
+
+	r.prometheus.operation.actions.WithLabelValues(task, action, metricsResultSkipped, reason).Inc()
+	return true
+}
+
+// node is not skipped anymore (finished or healthy), next skip is counted again
+func (r *AzureK8sAutopilot) metricsNodeSkipReset(task, name string) {
+	r.skipped.lock.Lock()
+	defer r.skipped.lock.Unlock()
+
+	delete(r.skipped.reasons, task+"/"+name)
+}
+
+// forget skipped nodes of task which are not in the list anymore (eg. orphaned instances which are gone)
+func (r *AzureK8sAutopilot) metricsNodeSkipPrune(task string, names map[string]bool) {
+	r.skipped.lock.Lock()
+	defer r.skipped.lock.Unlock()
+
+	for key := range r.skipped.reasons {
+		if keyTask, name, _ := strings.Cut(key, "/"); keyTask == task && !names[name] {
+			delete(r.skipped.reasons, key)
+		}
+	}
+}
+
+// remove per node series of deleted nodes
+func (r *AzureK8sAutopilot) metricsNodeDeleted(nodeName string) {
+	r.prometheus.repair.nodeStatus.DeleteLabelValues(nodeName)
+	r.prometheus.update.deferredNodes.DeleteLabelValues(nodeName)
+	r.prometheus.update.blockingPods.DeletePartialMatch(prometheus.Labels{"node": nodeName})
+
+	r.skipped.lock.Lock()
+	defer r.skipped.lock.Unlock()
+	for key := range r.skipped.reasons {
+		if _, name, _ := strings.Cut(key, "/"); name == nodeName {
+			delete(r.skipped.reasons, key)
+		}
+	}
+}
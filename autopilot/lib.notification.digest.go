@@ -0,0 +1,196 @@
+package autopilot
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	notificationDigestEvent    = "digest"
+	notificationDigestMaxNodes = 10
+)
+
+// checks if event is a duplicate or (with state changes only) no change of the node state
+func (r *AzureK8sAutopilot) notificationSuppressed(event NotificationEvent) bool {
+	r.notification.lock.Lock()
+	defer r.notification.lock.Unlock()
+
+	// identical events within dedup window
+	if r.Config.Notification.DedupWindow > 0 {
+		// error class instead of error text, Azure errors contain request ids
+		key := strings.Join([]string{event.Event, event.Node, event.Pool, event.Action, event.ErrorClass}, "|")
+		if _, exists := r.notification.dedup.Get(key); exists {
+			return true
+		}
+		r.notification.dedup.Set(key, true, cache.DefaultExpiration)
+	}
+
+	if !r.Config.Notification.StateChangesOnly || event.Node == "" {
+		return false
+	}
+
+	// node state is tracked per category (eg. repair, update)
+	category, state, _ := strings.Cut(event.Event, ".")
+	key := category + "/" + event.Node
+	switch state {
+	case "failed", "remove-failed":
+		// only first failure of a node is sent until it recovers
+		if r.notification.failing[key] {
+			return true
+		}
+		r.notification.failing[key] = true
+	case "started":
+		// retries of failing nodes are not sent
+		return r.notification.failing[key]
+	case "succeeded", "removed":
+		delete(r.notification.failing, key)
+	}
+
+	return false
+}
+
+// forget state of deleted node
+func (r *AzureK8sAutopilot) notificationNodeDeleted(nodeName string) {
+	r.notification.lock.Lock()
+	defer r.notification.lock.Unlock()
+
+	for key := range r.notification.failing {
+		if _, node, _ := strings.Cut(key, "/"); node == nodeName {
+			delete(r.notification.failing, key)
+		}
+	}
+}
+
+// batches event into next digest if digest is enabled for event
+func (r *AzureK8sAutopilot) notificationDigestAdd(event NotificationEvent) bool {
+	if r.Config.Notification.DigestInterval <= 0 || !notificationEventMatches(r.Config.Notification.DigestEvents, event.Event) {
+		return false
+	}
+
+	r.notification.lock.Lock()
+	defer r.notification.lock.Unlock()
+	r.notification.digest = append(r.notification.digest, event)
+	return true
+}
+
+// send digest periodically, pending events are sent on shutdown
+func (r *AzureK8sAutopilot) startNotificationDigest() {
+	r.Logger.Infof("starting notification digest with interval %v", r.Config.Notification.DigestInterval.String())
+
+	r.notification.digestStop = make(chan struct{})
+	r.notification.digestDone = make(chan struct{})
+
+	go func() {
+		defer close(r.notification.digestDone)
+
+		ticker := time.NewTicker(r.Config.Notification.DigestInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.notification.digestStop:
+				r.notificationDigestSend()
+				return
+			case <-ticker.C:
+				r.notificationDigestSend()
+			}
+		}
+	}()
+}
+
+// stop digest and wait until pending events are sent, must be called after ongoing actions finished
+func (r *AzureK8sAutopilot) stopNotificationDigest() {
+	if r.notification.digestStop == nil {
+		return
+	}
+
+	close(r.notification.digestStop)
+	<-r.notification.digestDone
+}
+
+// send summary of batched events to each route, routes only get events they are subscribed to
+func (r *AzureK8sAutopilot) notificationDigestSend() {
+	r.notification.lock.Lock()
+	events := r.notification.digest
+	r.notification.digest = nil
+	r.notification.lock.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+
+	for _, route := range r.notification.routes {
+		routeEvents := []NotificationEvent{}
+		for _, event := range events {
+			if notificationEventMatches(route.events, event.Event) {
+				routeEvents = append(routeEvents, event)
+			}
+		}
+
+		if len(routeEvents) == 0 {
+			continue
+		}
+
+		for _, err := range route.sender.Send(notificationDigestMessage(routeEvents), nil) {
+			if err != nil {
+				r.Logger.Error("unable to send shoutrrr notification", slog.String("event", notificationDigestEvent), slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// summary of events grouped by event and pool (eg. "update.succeeded: 12 nodes in pool X")
+func notificationDigestMessage(events []NotificationEvent) string {
+	type digestGroup struct {
+		event string
+		pool  string
+		count int
+		nodes []string
+	}
+
+	groups := map[string]*digestGroup{}
+	for _, event := range events {
+		key := event.Event + "|" + event.Pool
+		if _, exists := groups[key]; !exists {
+			groups[key] = &digestGroup{event: event.Event, pool: event.Pool}
+		}
+		groups[key].count++
+		if event.Node != "" {
+			groups[key].nodes = append(groups[key].nodes, event.Node)
+		}
+	}
+
+	keys := []string{}
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{
+		fmt.Sprintf("autopilot digest: %v events since %v", len(events), events[0].Time.Format(time.RFC3339)),
+	}
+	for _, key := range keys {
+		group := groups[key]
+		line := fmt.Sprintf("- %v: %v", group.event, group.count)
+		if group.pool != "" {
+			line += fmt.Sprintf(" in pool %v", group.pool)
+		}
+
+		nodes := group.nodes
+		if len(nodes) > notificationDigestMaxNodes {
+			nodes = append(nodes[:notificationDigestMaxNodes:notificationDigestMaxNodes], fmt.Sprintf("+%v more", len(group.nodes)-notificationDigestMaxNodes))
+		}
+		if len(nodes) > 0 {
+			line += fmt.Sprintf(" (%v)", strings.Join(nodes, ", "))
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
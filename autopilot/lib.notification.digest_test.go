@@ -0,0 +1,142 @@
+package autopilot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testNotificationAutopilot(dedupWindow time.Duration, stateChangesOnly bool) *AzureK8sAutopilot {
+	r := &AzureK8sAutopilot{}
+	r.Config.Notification.DedupWindow = dedupWindow
+	r.Config.Notification.StateChangesOnly = stateChangesOnly
+	r.initNotifications()
+	return r
+}
+
+func TestNotificationSuppressed(t *testing.T) {
+	type step struct {
+		event      NotificationEvent
+		suppressed bool
+	}
+
+	tests := []struct {
+		name             string
+		dedupWindow      time.Duration
+		stateChangesOnly bool
+		steps            []step
+	}{
+		{
+			name: "nothing suppressed by default",
+			steps: []step{
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1"}},
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1"}},
+			},
+		},
+		{
+			name:        "duplicates within dedup window",
+			dedupWindow: time.Hour,
+			steps: []step{
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1", ErrorClass: AzureErrorClassTransient, Error: "request id 1"}},
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1", ErrorClass: AzureErrorClassTransient, Error: "request id 2"}, suppressed: true},
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1", ErrorClass: AzureErrorClassQuota}},
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node2", ErrorClass: AzureErrorClassTransient}},
+				{event: NotificationEvent{Event: NotificationEventUpdateFailed, Node: "node1", ErrorClass: AzureErrorClassTransient}},
+			},
+		},
+		{
+			name:             "state changes only",
+			stateChangesOnly: true,
+			steps: []step{
+				{event: NotificationEvent{Event: NotificationEventRepairStarted, Node: "node1"}},
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1"}},
+				{event: NotificationEvent{Event: NotificationEventRepairStarted, Node: "node1"}, suppressed: true},
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1"}, suppressed: true},
+				// other category and node are tracked separately
+				{event: NotificationEvent{Event: NotificationEventUpdateFailed, Node: "node1"}},
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node2"}},
+				// recovered node is reported again
+				{event: NotificationEvent{Event: NotificationEventRepairSucceeded, Node: "node1"}},
+				{event: NotificationEvent{Event: NotificationEventRepairStarted, Node: "node1"}},
+				{event: NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1"}},
+				// events without node are not tracked
+				{event: NotificationEvent{Event: NotificationEventUpdateCircuitBreaker}},
+				{event: NotificationEvent{Event: NotificationEventUpdateCircuitBreaker}},
+			},
+		},
+		{
+			name:             "node removal",
+			stateChangesOnly: true,
+			steps: []step{
+				{event: NotificationEvent{Event: NotificationEventNodeRemoveFailed, Node: "node1"}},
+				{event: NotificationEvent{Event: NotificationEventNodeRemoveFailed, Node: "node1"}, suppressed: true},
+				{event: NotificationEvent{Event: NotificationEventNodeRemoved, Node: "node1"}},
+				{event: NotificationEvent{Event: NotificationEventNodeRemoveFailed, Node: "node1"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := testNotificationAutopilot(test.dedupWindow, test.stateChangesOnly)
+			for i, step := range test.steps {
+				if suppressed := r.notificationSuppressed(step.event); suppressed != step.suppressed {
+					t.Errorf("step %v (%v %v): expected suppressed=%v, got %v", i, step.event.Event, step.event.Node, step.suppressed, suppressed)
+				}
+			}
+		})
+	}
+}
+
+func TestNotificationNodeDeleted(t *testing.T) {
+	r := testNotificationAutopilot(0, true)
+
+	r.notificationSuppressed(NotificationEvent{Event: NotificationEventRepairFailed, Node: "node1"})
+	r.notificationSuppressed(NotificationEvent{Event: NotificationEventUpdateFailed, Node: "node1"})
+	r.notificationSuppressed(NotificationEvent{Event: NotificationEventRepairFailed, Node: "node2"})
+
+	r.notificationNodeDeleted("node1")
+	if len(r.notification.failing) != 1 || !r.notification.failing["repair/node2"] {
+		t.Errorf("expected only repair/node2 to be failing, got %v", r.notification.failing)
+	}
+}
+
+func TestNotificationDigestMessage(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	events := []NotificationEvent{
+		{Event: NotificationEventUpdateSucceeded, Node: "node1", Pool: "pool1", Time: start},
+		{Event: NotificationEventUpdateSucceeded, Node: "node2", Pool: "pool1", Time: start.Add(time.Minute)},
+		{Event: NotificationEventUpdateSucceeded, Node: "node3", Pool: "pool2", Time: start.Add(time.Minute)},
+		{Event: NotificationEventRepairStarted, Node: "vm1", Time: start.Add(2 * time.Minute)},
+		{Event: NotificationEventUpdateCircuitBreaker, Time: start.Add(3 * time.Minute)},
+	}
+
+	expect := strings.Join([]string{
+		"autopilot digest: 5 events since 2024-01-01T12:00:00Z",
+		"- repair.started: 1 (vm1)",
+		"- update.circuit-breaker: 1",
+		"- update.succeeded: 2 in pool pool1 (node1, node2)",
+		"- update.succeeded: 1 in pool pool2 (node3)",
+	}, "\n")
+
+	if message := notificationDigestMessage(events); message != expect {
+		t.Errorf("unexpected digest message:\n%v\nexpected:\n%v", message, expect)
+	}
+}
+
+func TestNotificationDigestMessageMaxNodes(t *testing.T) {
+	events := []NotificationEvent{}
+	for i := 0; i < notificationDigestMaxNodes+3; i++ {
+		events = append(events, NotificationEvent{Event: NotificationEventUpdateSucceeded, Node: "node" + string(rune('a'+i)), Pool: "pool1"})
+	}
+
+	lines := strings.Split(notificationDigestMessage(events), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %v", lines)
+	}
+
+	if !strings.HasPrefix(lines[1], "- update.succeeded: 13 in pool pool1 (nodea, ") || !strings.HasSuffix(lines[1], ", nodej, +3 more)") {
+		t.Errorf("unexpected digest line: %v", lines[1])
+	}
+}
@@ -0,0 +1,242 @@
+package autopilot
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/containrrr/shoutrrr"
+	"github.com/containrrr/shoutrrr/pkg/router"
+	"github.com/patrickmn/go-cache"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	NotificationEventRepairStarted   = "repair.started"
+	NotificationEventRepairSucceeded = "repair.succeeded"
+	NotificationEventRepairFailed    = "repair.failed"
+
+	NotificationEventUpdateStarted        = "update.started"
+	NotificationEventUpdateSucceeded      = "update.succeeded"
+	NotificationEventUpdateFailed         = "update.failed"
+	NotificationEventUpdateDrainBlocked   = "update.drain-blocked"
+	NotificationEventUpdateCircuitBreaker = "update.circuit-breaker"
+
+	NotificationEventOrphanStarted   = "orphan.started"
+	NotificationEventOrphanSucceeded = "orphan.succeeded"
+	NotificationEventOrphanFailed    = "orphan.failed"
+
+	NotificationEventNodeRemoved      = "node.removed"
+	NotificationEventNodeRemoveFailed = "node.remove-failed"
+
+	NotificationEventSmokeTestEscalated = "smoketest.escalated"
+
+	notificationDefaultTemplate = "{{.Message}}"
+)
+
+type (
+	// notification config file (--notification.config)
+	notificationConfig struct {
+		// templates by event (glob pattern, eg. "repair.*"), "*" is used as default
+		Templates map[string]string `json:"templates"`
+
+		// routing rules, events are sent to all matching routes
+		Routes []notificationRouteConfig `json:"routes"`
+	}
+
+	notificationRouteConfig struct {
+		Events []string `json:"events"`
+		Urls   []string `json:"urls"`
+	}
+
+	notificationRoute struct {
+		events []string
+		sender *router.ServiceRouter
+	}
+
+	notificationTemplate struct {
+		pattern  string
+		template *template.Template
+	}
+
+	// NotificationEvent is passed to notification templates
+	NotificationEvent struct {
+		Event    string
+		Message  string
+		Node     string
+		Pool     string
+		Action   string
+		Duration time.Duration
+		Error    string
+		// classified error (eg. throttled, quota), used for deduplication
+		ErrorClass string
+		Time       time.Time
+	}
+)
+
+// create notification senders (once) and parse templates
+func (r *AzureK8sAutopilot) initNotifications() {
+	config := notificationConfig{}
+	if r.Config.Notification.Config != "" {
+		content, err := os.ReadFile(r.Config.Notification.Config)
+		if err != nil {
+			r.Logger.Panic(err.Error())
+		}
+
+		if err := yaml.UnmarshalStrict(content, &config); err != nil {
+			r.Logger.Panic(fmt.Sprintf("unable to parse notification config: %v", err))
+		}
+	}
+
+	// urls from --notification receive all events
+	if len(r.Config.Notification.Urls) > 0 {
+		config.Routes = append(config.Routes, notificationRouteConfig{
+			Events: []string{"*"},
+			Urls:   r.Config.Notification.Urls,
+		})
+	}
+
+	for _, routeConfig := range config.Routes {
+		if len(routeConfig.Urls) == 0 {
+			continue
+		}
+
+		sender, err := shoutrrr.CreateSender(routeConfig.Urls...)
+		if err != nil {
+			r.Logger.Panic(fmt.Sprintf("unable to create shoutrrr notification sender: %v", err))
+		}
+
+		events := routeConfig.Events
+		if len(events) == 0 {
+			events = []string{"*"}
+		}
+
+		r.notification.routes = append(r.notification.routes, notificationRoute{events: events, sender: sender})
+	}
+
+	if _, exists := config.Templates["*"]; !exists {
+		if config.Templates == nil {
+			config.Templates = map[string]string{}
+		}
+		config.Templates["*"] = notificationDefaultTemplate
+	}
+
+	for pattern, content := range config.Templates {
+		tmpl, err := template.New(pattern).Parse(content)
+		if err != nil {
+			r.Logger.Panic(fmt.Sprintf("unable to parse notification template \"%s\": %v", pattern, err))
+		}
+		r.notification.templates = append(r.notification.templates, notificationTemplate{pattern: pattern, template: tmpl})
+	}
+
+	r.notification.dedup = cache.New(r.Config.Notification.DedupWindow, 1*time.Minute)
+	r.notification.failing = map[string]bool{}
+}
+
+// match event name against glob patterns (eg. "repair.*")
+func notificationEventMatches(patterns []string, event string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, event); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// template for event, most specific (longest) matching pattern wins
+func (r *AzureK8sAutopilot) notificationTemplate(event string) *template.Template {
+	var ret *notificationTemplate
+	for i, tmpl := range r.notification.templates {
+		if notificationEventMatches([]string{tmpl.pattern}, event) && (ret == nil || len(tmpl.pattern) > len(ret.pattern)) {
+			ret = &r.notification.templates[i]
+		}
+	}
+
+	if ret == nil {
+		return nil
+	}
+	return ret.template
+}
+
+// send notification event to all matching routes (unless suppressed or batched into digest)
+func (r *AzureK8sAutopilot) notify(event NotificationEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if r.notificationSuppressed(event) {
+		r.Logger.Debug("notification suppressed", slog.String("event", event.Event), slog.String("node", event.Node))
+		return
+	}
+
+	if r.notificationDigestAdd(event) {
+		return
+	}
+
+	r.notificationSend(event.Event, r.notificationRender(event))
+}
+
+// render notification message using template of event
+func (r *AzureK8sAutopilot) notificationRender(event NotificationEvent) string {
+	message := event.Message
+	if tmpl := r.notificationTemplate(event.Event); tmpl != nil {
+		buf := bytes.Buffer{}
+		if err := tmpl.Execute(&buf, event); err != nil {
+			r.Logger.Error("unable to render notification template", slog.String("event", event.Event), slog.Any("error", err))
+		} else {
+			message = strings.TrimSpace(buf.String())
+		}
+	}
+
+	return message
+}
+
+// send message to all routes matching the event
+func (r *AzureK8sAutopilot) notificationSend(event, message string) {
+	for _, route := range r.notification.routes {
+		if !notificationEventMatches(route.events, event) {
+			continue
+		}
+
+		for _, err := range route.sender.Send(message, nil) {
+			if err != nil {
+				r.Logger.Error("unable to send shoutrrr notification", slog.String("event", event), slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// error text for notification events
+func notificationError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// send notification event for a node target
+func (r *AzureK8sAutopilot) notifyNodeTarget(event string, target *nodeTarget, action, message string, err error) {
+	notification := NotificationEvent{
+		Event:      event,
+		Message:    message,
+		Node:       target.node.Name,
+		Action:     action,
+		Error:      notificationError(err),
+		ErrorClass: azureErrorClass(err),
+	}
+
+	if target.info != nil {
+		notification.Pool = target.info.VMScaleSetName
+	}
+
+	if !target.started.IsZero() {
+		notification.Duration = time.Since(target.started).Round(time.Second)
+	}
+
+	r.notify(notification)
+}
@@ -0,0 +1,161 @@
+package autopilot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+)
+
+const (
+	// nothing to do (eg. node is healthy)
+	PlanDecisionNone = "none"
+	// node needs action but threshold or grace period is not reached yet
+	PlanDecisionWait = "wait"
+	// node needs action but is blocked by a check
+	PlanDecisionSkip = "skip"
+	// action is triggered (only planned in dry run)
+	PlanDecisionAction = "action"
+)
+
+type (
+	// PlanReport contains the evaluated checks and decisions of one run (exposed by /plan)
+	PlanReport struct {
+		Task     string      `json:"task"`
+		Trigger  string      `json:"trigger"`
+		DryRun   bool        `json:"dryRun"`
+		Started  time.Time   `json:"started"`
+		Finished time.Time   `json:"finished"`
+		Checks   []PlanCheck `json:"checks,omitempty"`
+		Nodes    []*PlanNode `json:"nodes"`
+	}
+
+	// PlanNode is the decision for one node (or VMSS instance)
+	PlanNode struct {
+		Node     string      `json:"node"`
+		Checks   []PlanCheck `json:"checks"`
+		Decision string      `json:"decision"`
+		Action   string      `json:"action,omitempty"`
+		Reason   string      `json:"reason"`
+	}
+
+	// PlanCheck is an evaluated check, blocking checks prevent the action
+	PlanCheck struct {
+		Name     string `json:"name"`
+		Value    string `json:"value"`
+		Blocking bool   `json:"blocking"`
+	}
+)
+
+func newPlanReport(task, trigger string, dryRun bool) *PlanReport {
+	return &PlanReport{
+		Task:    task,
+		Trigger: trigger,
+		DryRun:  dryRun,
+		Started: time.Now(),
+		Nodes:   []*PlanNode{},
+	}
+}
+
+// add run check (eg. failed threshold)
+func (p *PlanReport) check(name string, blocking bool, format string, args ...any) {
+	p.Checks = append(p.Checks, PlanCheck{Name: name, Value: fmt.Sprintf(format, args...), Blocking: blocking})
+}
+
+// returns plan entry of node, entry is created if node is not part of the plan yet
+func (p *PlanReport) node(name string) *PlanNode {
+	for _, node := range p.Nodes {
+		if node.Node == name {
+			return node
+		}
+	}
+
+	node := &PlanNode{Node: name, Checks: []PlanCheck{}, Decision: PlanDecisionNone}
+	p.Nodes = append(p.Nodes, node)
+	return node
+}
+
+// add evaluated node check
+func (n *PlanNode) check(name string, blocking bool, format string, args ...any) {
+	n.Checks = append(n.Checks, PlanCheck{Name: name, Value: fmt.Sprintf(format, args...), Blocking: blocking})
+}
+
+// set decision of node
+func (n *PlanNode) decide(decision, action, reason string) {
+	n.Decision = decision
+	n.Action = action
+	n.Reason = reason
+}
+
+// log decisions of run (info level in dry run) and keep report for /plan
+func (r *AzureK8sAutopilot) planFinish(contextLogger *slogger.Logger, report *PlanReport) {
+	report.Finished = time.Now()
+
+	decisions := map[string]int{}
+	for _, node := range report.Nodes {
+		decisions[node.Decision]++
+
+		nodeLogger := contextLogger.With(
+			slog.String("node", node.Node),
+			slog.String("decision", node.Decision),
+			slog.String("action", node.Action),
+			slog.String("reason", node.Reason),
+			slog.Any("checks", node.Checks),
+		)
+		if report.DryRun {
+			nodeLogger.Info("dry run plan for node")
+		} else {
+			nodeLogger.Debug("plan for node")
+		}
+	}
+
+	summaryLogger := contextLogger.With(slog.Any("checks", report.Checks), slog.Any("decisions", decisions))
+	if report.DryRun {
+		summaryLogger.Info("dry run plan finished", slog.Int("nodes", len(report.Nodes)))
+	} else {
+		summaryLogger.Debug("plan finished", slog.Int("nodes", len(report.Nodes)))
+	}
+
+	r.plan.lock.Lock()
+	defer r.plan.lock.Unlock()
+	r.plan.reports[report.Task+"/"+report.Trigger] = report
+}
+
+// Plan returns the latest plan report of each task and trigger
+func (r *AzureK8sAutopilot) Plan() []PlanReport {
+	r.plan.lock.Lock()
+	defer r.plan.lock.Unlock()
+
+	ret := []PlanReport{}
+	for _, report := range r.plan.reports {
+		ret = append(ret, *report)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Task != ret[j].Task {
+			return ret[i].Task < ret[j].Task
+		}
+		return ret[i].Trigger < ret[j].Trigger
+	})
+
+	return ret
+}
+
+// evaluate provisioning state before the planned action (only in dry run, checked before the action otherwise)
+func (r *AzureK8sAutopilot) planCheckProvisionState(ctx context.Context, target *nodeTarget, plan *PlanNode) bool {
+	if err := r.nodeTargetCheckProvisionState(ctx, target); err != nil {
+		plan.check("provisioningState", true, "%v", err)
+		plan.decide(PlanDecisionSkip, target.action, "provisioning state does not allow action")
+		return false
+	}
+
+	if provisioningState, exists := target.inputs["provisioningState"]; exists {
+		plan.check("provisioningState", false, "%v", provisioningState)
+	} else {
+		plan.check("provisioningState", false, "unknown")
+	}
+	return true
+}
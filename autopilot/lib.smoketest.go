@@ -0,0 +1,151 @@
+package autopilot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+const (
+	SmokeTestFailureActionKeepCordoned = "keep-cordoned"
+	SmokeTestFailureActionRetry        = "retry"
+	SmokeTestFailureActionEscalate     = "escalate"
+
+	smokeTestPollInterval = 5 * time.Second
+)
+
+// load smoke test pod template
+func (r *AzureK8sAutopilot) initSmokeTest() {
+	if r.Config.SmokeTest.PodTemplate == "" {
+		return
+	}
+
+	content, err := os.ReadFile(r.Config.SmokeTest.PodTemplate)
+	if err != nil {
+		r.Logger.Panic(err.Error())
+	}
+
+	pod := corev1.Pod{}
+	if err := yaml.UnmarshalStrict(content, &pod); err != nil {
+		r.Logger.Panic(fmt.Sprintf("unable to parse smoke test pod template: %v", err))
+	}
+
+	r.smokeTestPod = &pod
+}
+
+// run smoke test pod on node, retried depending on failure action
+func (r *AzureK8sAutopilot) smokeTestRun(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) error {
+	if r.smokeTestPod == nil {
+		return nil
+	}
+
+	attempts := 1
+	if r.Config.SmokeTest.FailureAction == SmokeTestFailureActionRetry {
+		attempts += r.Config.SmokeTest.Retries
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = r.smokeTestRunPod(ctx, contextLogger, node); err == nil {
+			return nil
+		}
+		contextLogger.Warn("smoke test failed", slog.Int("attempt", attempt), slog.Any("error", err))
+	}
+
+	// mark node, it is not uncordoned when the lock expires
+	if k8sErr := node.AnnotationSet(ctx, r.Config.SmokeTest.FailedAnnotation, time.Now().Format(time.RFC3339)); k8sErr != nil {
+		contextLogger.Error("unable to set smoke test failed annotation", slog.Any("error", k8sErr))
+	}
+
+	// repaired nodes are not cordoned if they were not drained before
+	if k8sErr := r.k8sCordonNode(ctx, contextLogger, node); k8sErr != nil {
+		contextLogger.Error("unable to cordon node", slog.Any("error", k8sErr))
+	}
+
+	if r.Config.SmokeTest.FailureAction == SmokeTestFailureActionEscalate {
+		if eventErr := r.k8sNodeEvent(ctx, node, corev1.EventTypeWarning, "SmokeTestFailed", err.Error()); eventErr != nil {
+			contextLogger.Error("unable to create node event", slog.Any("error", eventErr))
+		}
+		r.notify(NotificationEvent{
+			Event:      NotificationEventSmokeTestEscalated,
+			Message:    fmt.Sprintf("ESCALATION: smoke test of K8s node %v failed, node stays cordoned: %v", node.Name, err),
+			Node:       node.Name,
+			Action:     r.Config.SmokeTest.FailureAction,
+			Error:      err.Error(),
+			ErrorClass: azureErrorClass(err),
+		})
+	}
+
+	return fmt.Errorf("smoke test failed: %w", err)
+}
+
+// run smoke test pod pinned to node and wait for completion
+func (r *AzureK8sAutopilot) smokeTestRunPod(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) error {
+	pod := r.smokeTestPod.DeepCopy()
+	pod.Name = ""
+	if pod.GenerateName == "" {
+		pod.GenerateName = "autopilot-smoketest-"
+	}
+	pod.Namespace = r.Config.SmokeTest.Namespace
+	pod.Spec.NodeName = node.Name
+	pod.Spec.RestartPolicy = corev1.RestartPolicyNever
+	pod.Spec.Tolerations = append(
+		pod.Spec.Tolerations,
+		corev1.Toleration{Key: corev1.TaintNodeUnschedulable, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+		// out-of-service taint of repaired nodes is removed after verification
+		corev1.Toleration{Key: k8s.OutOfServiceTaintKey, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, r.Config.SmokeTest.Timeout)
+	defer cancel()
+
+	podClient := r.k8sClient.CoreV1().Pods(pod.Namespace)
+	pod, err := podClient.Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	podLogger := contextLogger.With(slog.String("pod", pod.Namespace+"/"+pod.Name))
+	podLogger.Info("started smoke test pod")
+
+	defer func() {
+		// cleanup, ctx might already be expired
+		deleteCtx, deleteCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer deleteCancel()
+		propagation := metav1.DeletePropagationBackground
+		if err := podClient.Delete(deleteCtx, pod.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			podLogger.Warn("unable to delete smoke test pod", slog.Any("error", err))
+		}
+	}()
+
+	for {
+		select {
+		case <-time.After(smokeTestPollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("smoke test pod %s/%s did not complete within %s", pod.Namespace, pod.Name, r.Config.SmokeTest.Timeout.String())
+		}
+
+		current, err := podClient.Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			podLogger.Warn("unable to fetch smoke test pod", slog.Any("error", err))
+			continue
+		}
+
+		switch current.Status.Phase {
+		case corev1.PodSucceeded:
+			podLogger.Info("smoke test pod succeeded")
+			return nil
+		case corev1.PodFailed:
+			return fmt.Errorf("smoke test pod %s/%s failed: %s", pod.Namespace, pod.Name, current.Status.Message)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package autopilot
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/webdevopos/azure-k8s-autopilot/autopilot"
+
+	tracingShutdownTimeout = 10 * time.Second
+
+	attributeNode                 = attribute.Key("k8s.node.name")
+	attributeNodes                = attribute.Key("k8s.node.names")
+	attributeVmss                 = attribute.Key("azure.vmss.name")
+	attributeResourceGroup        = attribute.Key("azure.resource_group")
+	attributeAction               = attribute.Key("autopilot.action")
+	attributeAzureRequestId       = attribute.Key("azure.request_id")
+	attributeAzureCorrelationId   = attribute.Key("azure.correlation_request_id")
+	azureRequestIdHeader          = "x-ms-request-id"
+	azureResponseCorrelationIdKey = "x-ms-correlation-request-id"
+)
+
+// global tracer, spans are dropped if tracing is disabled (noop provider)
+var tracer = otel.Tracer(tracerName)
+
+type (
+	// creates span for each Azure request (including long running operation polls)
+	azureTracingPolicy struct{}
+)
+
+// setup OpenTelemetry trace export via OTLP/HTTP
+func (r *AzureK8sAutopilot) initTracing() {
+	if r.Config.Tracing.Endpoint == "" {
+		return
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(r.Config.Tracing.Endpoint),
+	}
+	if r.Config.Tracing.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		r.Logger.Panic(err.Error())
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(r.Config.Tracing.ServiceName)),
+	)
+	if err != nil {
+		r.Logger.Panic(err.Error())
+	}
+
+	r.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(r.Config.Tracing.SampleRatio))),
+	)
+	otel.SetTracerProvider(r.tracerProvider)
+
+	r.Logger.Infof("sending OpenTelemetry traces to %v", r.Config.Tracing.Endpoint)
+}
+
+// flush pending spans
+func (r *AzureK8sAutopilot) shutdownTracing() {
+	if r.tracerProvider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := r.tracerProvider.Shutdown(ctx); err != nil {
+		r.Logger.Error(err.Error())
+	}
+}
+
+// Azure client options with request tracing
+func (r *AzureK8sAutopilot) azureClientOptions() *arm.ClientOptions {
+	opts := r.azureClient.NewArmClientOptions()
+	opts.PerRetryPolicies = append(opts.PerRetryPolicies, azureTracingPolicy{})
+	return opts
+}
+
+func (p azureTracingPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	ctx, span := tracer.Start(
+		raw.Context(),
+		"azure "+raw.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(raw.Method),
+			semconv.URLPath(raw.URL.Path),
+		),
+	)
+	defer span.End()
+
+	resp, err := req.WithContext(ctx).Next()
+	if resp != nil {
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(resp.StatusCode),
+			attributeAzureRequestId.String(resp.Header.Get(azureRequestIdHeader)),
+			attributeAzureCorrelationId.String(resp.Header.Get(azureResponseCorrelationIdKey)),
+		)
+		if resp.StatusCode >= 400 {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	spanError(span, err)
+
+	return resp, err
+}
+
+// records error on span
+func spanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// attributes of node targets (node names, VMSS and action)
+func nodeTargetSpanAttributes(targetList []*nodeTarget, action string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attributeNodes.StringSlice(nodeTargetNames(targetList)),
+		attributeAction.String(action),
+	}
+	if len(targetList) > 0 && targetList[0].info != nil {
+		attrs = append(
+			attrs,
+			attributeResourceGroup.String(targetList[0].info.ResourceGroup),
+			attributeVmss.String(targetList[0].info.VMScaleSetName),
+		)
+	}
+	return attrs
+}
@@ -0,0 +1,252 @@
+package autopilot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+const (
+	LifecyclePhaseDetected     = "detected"
+	LifecyclePhaseLocked       = "locked"
+	LifecyclePhaseDrain        = "drain"
+	LifecyclePhaseAzureAction  = "azure-action"
+	LifecyclePhaseVerification = "verification"
+	LifecyclePhaseUncordon     = "uncordon"
+
+	LifecycleStatusStarted   = "started"
+	LifecycleStatusSucceeded = "succeeded"
+	LifecycleStatusFailed    = "failed"
+	LifecycleStatusSkipped   = "skipped"
+
+	webhookEventTypePrefix   = "io.webdevops.autopilot"
+	webhookContentType       = "application/cloudevents+json"
+	webhookSignatureHeader   = "X-Autopilot-Signature"
+	webhookRetryBackoffMax   = 1 * time.Minute
+	webhookShutdownTimeout   = 10 * time.Second
+	azureCorrelationIdHeader = "x-ms-correlation-request-id"
+)
+
+type (
+	// structured CloudEvent (https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/formats/json-format.md)
+	cloudEvent struct {
+		SpecVersion     string             `json:"specversion"`
+		ID              string             `json:"id"`
+		Source          string             `json:"source"`
+		Type            string             `json:"type"`
+		Subject         string             `json:"subject,omitempty"`
+		Time            time.Time          `json:"time"`
+		DataContentType string             `json:"datacontenttype"`
+		Data            lifecycleEventData `json:"data"`
+	}
+
+	// node lifecycle transition driven by autopilot
+	lifecycleEventData struct {
+		Task               string        `json:"task"`
+		Phase              string        `json:"phase"`
+		Status             string        `json:"status,omitempty"`
+		Node               string        `json:"node,omitempty"`
+		NodeInfo           *k8s.NodeInfo `json:"nodeInfo,omitempty"`
+		Action             string        `json:"action,omitempty"`
+		AzureCorrelationId string        `json:"azureCorrelationId,omitempty"`
+		Error              string        `json:"error,omitempty"`
+		DryRun             bool          `json:"dryRun"`
+	}
+)
+
+// start webhook worker sending queued events
+func (r *AzureK8sAutopilot) initWebhook() {
+	if r.Config.Webhook.Url == "" {
+		return
+	}
+
+	r.webhook.queue = make(chan cloudEvent, r.Config.Webhook.QueueSize)
+	r.webhook.client = &http.Client{Timeout: r.Config.Webhook.Timeout}
+	r.webhook.ctx, r.webhook.cancel = context.WithCancel(context.Background())
+	r.webhook.stop = make(chan struct{})
+	r.webhook.done = make(chan struct{})
+
+	go func() {
+		defer close(r.webhook.done)
+		for {
+			select {
+			case event := <-r.webhook.queue:
+				r.webhookSend(r.webhook.ctx, event)
+			case <-r.webhook.stop:
+				// send remaining events (until cancelled)
+				for {
+					select {
+					case event := <-r.webhook.queue:
+						r.webhookSend(r.webhook.ctx, event)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// stop webhook worker after sending queued events, remaining events are dropped after the shutdown deadline
+func (r *AzureK8sAutopilot) stopWebhook() {
+	if r.webhook.stop == nil {
+		return
+	}
+
+	close(r.webhook.stop)
+	select {
+	case <-r.webhook.done:
+	case <-time.After(webhookShutdownTimeout):
+		r.Logger.Warn("webhook events not sent before shutdown deadline, dropping remaining events", slog.Int("events", len(r.webhook.queue)), slog.Duration("timeout", webhookShutdownTimeout))
+		r.webhook.cancel()
+		<-r.webhook.done
+	}
+	r.webhook.cancel()
+}
+
+// queue lifecycle event for the webhook, events are dropped if the queue is full
+func (r *AzureK8sAutopilot) lifecycleEvent(task, phase, status, action string, target *nodeTarget, err error) {
+	if r.webhook.queue == nil {
+		return
+	}
+
+	data := lifecycleEventData{
+		Task:   task,
+		Phase:  phase,
+		Status: status,
+		Action: action,
+		Error:  notificationError(err),
+		DryRun: r.Config.DryRun,
+	}
+
+	if target != nil {
+		data.NodeInfo = target.info
+		data.AzureCorrelationId = target.correlationId
+		if target.node != nil {
+			data.Node = target.node.Name
+		} else if target.info != nil {
+			data.Node = target.info.NodeName
+		}
+	}
+
+	eventType := fmt.Sprintf("%s.%s.%s", webhookEventTypePrefix, task, phase)
+	if status != "" {
+		eventType += "." + status
+	}
+
+	event := cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              uuid.New().String(),
+		Source:          r.Config.Webhook.Source,
+		Type:            eventType,
+		Subject:         data.Node,
+		Time:            time.Now(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+
+	select {
+	case r.webhook.queue <- event:
+	default:
+		r.Logger.Warn("webhook queue full, dropping event", slog.String("type", event.Type), slog.String("node", data.Node))
+	}
+}
+
+// post event to webhook, retried with exponential backoff
+func (r *AzureK8sAutopilot) webhookSend(ctx context.Context, event cloudEvent) {
+	contextLogger := r.Logger.With(slog.String("type", event.Type), slog.String("id", event.ID))
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		contextLogger.Error("unable to marshal webhook event", slog.Any("error", err))
+		return
+	}
+
+	backoff := 1 * time.Second
+	for attempt := 0; attempt <= r.Config.Webhook.Retries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				contextLogger.Error("unable to send webhook event, cancelled", slog.Any("error", err))
+				return
+			}
+			backoff = min(backoff*2, webhookRetryBackoffMax)
+		}
+
+		if err = r.webhookPost(ctx, body); err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			contextLogger.Error("unable to send webhook event, cancelled", slog.Any("error", err))
+			return
+		}
+		contextLogger.Warn("unable to send webhook event", slog.Int("attempt", attempt+1), slog.Any("error", err))
+	}
+
+	contextLogger.Error("unable to send webhook event, giving up", slog.Any("error", err))
+}
+
+func (r *AzureK8sAutopilot) webhookPost(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Config.Webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", webhookContentType)
+	req.Header.Set("User-Agent", r.UserAgent)
+
+	// HMAC-SHA256 signature of body
+	if r.Config.Webhook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(r.Config.Webhook.Secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := r.webhook.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %v", resp.Status)
+	}
+	return nil
+}
+
+// sets correlation ID for Azure requests, used to match webhook events and audit entries with the Azure activity log
+func azureCorrelationContext(ctx context.Context, targetList ...*nodeTarget) context.Context {
+	correlationId := uuid.New().String()
+	for _, target := range targetList {
+		target.correlationId = correlationId
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attributeAzureCorrelationId.String(correlationId))
+
+	header := http.Header{}
+	header.Set(azureCorrelationIdHeader, correlationId)
+	return policy.WithHTTPHeader(auditTargetContext(ctx, targetList...), header)
+}
+
+// lifecycle status depending on result
+func lifecycleStatus(err error) string {
+	if err != nil {
+		return LifecycleStatusFailed
+	}
+	return LifecycleStatusSucceeded
+}
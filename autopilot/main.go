@@ -2,14 +2,15 @@ package autopilot
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/containrrr/shoutrrr"
 	"github.com/go-logr/logr"
 	"github.com/operator-framework/operator-lib/leader"
 	"github.com/patrickmn/go-cache"
@@ -18,22 +19,34 @@ import (
 	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/azuresdk/azidentity"
 	"github.com/webdevops/go-common/log/slogger"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/policy/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
+	k8scache "k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/webdevopos/azure-k8s-autopilot/config"
 	"github.com/webdevopos/azure-k8s-autopilot/k8s"
 )
 
+const (
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// time to persist state of cancelled actions after the shutdown grace period
+	shutdownCancelTimeout = 5 * time.Second
+)
+
 type (
 	AzureK8sAutopilot struct {
-		ctx    context.Context
-		Config config.Opts
+		ctx       context.Context
+		ctxCancel context.CancelFunc
+		Config    config.Opts
 
 		UserAgent string
 
@@ -42,27 +55,69 @@ type (
 		cron struct {
 			repair *cron.Cron
 			update *cron.Cron
+			orphan *cron.Cron
 		}
 
 		wg sync.WaitGroup
 
+		// shutdown was started, no new work is accepted (set while holding workLock, see workStart)
+		stopping atomic.Bool
+		workLock sync.Mutex
+
+		// written by leader election, read by http handlers
+		isLeader atomic.Bool
+
+		// last skip reason per task and node, skipped nodes are counted once per reason
+		skipped struct {
+			reasons map[string]string
+			lock    sync.Mutex
+		}
+
+		azureState struct {
+			lock           sync.Mutex
+			throttledUntil time.Time
+			authError      error
+		}
+
 		prometheus struct {
 			general struct {
 				errors         *prometheus.CounterVec
 				candidateNodes *prometheus.GaugeVec
 				failedNodes    *prometheus.GaugeVec
+				skippedNodes   *prometheus.CounterVec
+			}
+
+			azureInventory struct {
+				duration  *prometheus.GaugeVec
+				requests  *prometheus.CounterVec
+				resources *prometheus.GaugeVec
 			}
 
 			repair struct {
 				count      *prometheus.CounterVec
 				nodeStatus *prometheus.GaugeVec
 				duration   *prometheus.GaugeVec
+				inflight   *prometheus.GaugeVec
 			}
 
 			update struct {
+				count         *prometheus.CounterVec
+				duration      *prometheus.GaugeVec
+				deferredNodes *prometheus.GaugeVec
+				blockingPods  *prometheus.GaugeVec
+			}
+
+			orphan struct {
 				count    *prometheus.CounterVec
 				duration *prometheus.GaugeVec
 			}
+
+			operation struct {
+				azureDuration       *prometheus.HistogramVec
+				drainDuration       *prometheus.HistogramVec
+				maintenanceDuration *prometheus.HistogramVec
+				actions             *prometheus.CounterVec
+			}
 		}
 
 		azureClient *armclient.ArmClient
@@ -70,14 +125,95 @@ type (
 
 		cache *cache.Cache
 
+		smokeTestPod *corev1.Pod
+
+		volumeAttachmentInformer k8scache.SharedIndexInformer
+
+		notification struct {
+			routes    []notificationRoute
+			templates []notificationTemplate
+
+			// recently sent events for deduplication
+			dedup *cache.Cache
+
+			// nodes with failed action (category/node), used for state change notifications
+			failing map[string]bool
+
+			// events batched for next digest
+			digest []NotificationEvent
+
+			// digest goroutine is stopped on shutdown after sending pending events
+			digestStop chan struct{}
+			digestDone chan struct{}
+
+			lock sync.Mutex
+		}
+
+		webhook struct {
+			queue  chan cloudEvent
+			client *http.Client
+
+			// worker sends queued events on shutdown until the queue is empty or the deadline is reached
+			ctx    context.Context
+			cancel context.CancelFunc
+			stop   chan struct{}
+			done   chan struct{}
+		}
+
+		tracerProvider *sdktrace.TracerProvider
+
+		auditLog struct {
+			writer io.Writer
+
+			// pending entries (JSON lines) not yet written to the audit ConfigMap
+			entries   []string
+			namespace string
+			flush     chan struct{}
+
+			lock sync.Mutex
+		}
+
+		// latest plan report by task and trigger
+		plan struct {
+			reports map[string]*PlanReport
+			lock    sync.Mutex
+		}
+
 		nodeList *k8s.NodeList
 
 		repair struct {
 			nodeLock *cache.Cache
+			queue    workqueue.TypedRateLimitingInterface[string]
+			lock     sync.Mutex
+
+			inflight     map[string]context.CancelFunc
+			inflightLock sync.Mutex
+
+			// nodes where the Azure VM was not found
+			missingSince map[string]time.Time
+
+			// alert name to repair action
+			alertRules map[string]string
+
+			// received alerts, processed by repairAlertWorker
+			alertQueue chan []alertmanagerAlert
 		}
 
 		update struct {
 			nodeLock *cache.Cache
+
+			// nodes where drain is deferred by pod annotations
+			deferred     map[string]*updateDeferral
+			deferredLock sync.Mutex
+
+			// failed threshold reached, updates are stopped
+			circuitBreakerOpen bool
+		}
+
+		orphan struct {
+			instanceLock *cache.Cache
+			firstSeen    map[string]time.Time
+			lock         sync.Mutex
 		}
 	}
 
@@ -91,24 +227,52 @@ func (l *AzureK8sAutopilotLogger) Printf(msg string, args ...any) {
 }
 
 func (r *AzureK8sAutopilot) Init() {
+	r.initTracing()
 	r.initAzure()
 	r.initK8s()
+	r.initAudit()
 	r.initMetricsGeneral()
+	r.initMetricsAzureInventory()
 	r.initMetricsRepair()
 	r.initMetricsUpdate()
+	r.initMetricsOrphan()
+	r.initMetricsOperation()
 	r.cache = cache.New(1*time.Minute, 1*time.Minute)
 	r.repair.nodeLock = cache.New(15*time.Minute, 1*time.Minute)
+	r.repair.inflight = map[string]context.CancelFunc{}
+	r.repair.missingSince = map[string]time.Time{}
 	r.update.nodeLock = cache.New(15*time.Minute, 1*time.Minute)
-	r.ctx = context.Background()
+	r.update.deferred = map[string]*updateDeferral{}
+	r.orphan.instanceLock = cache.New(15*time.Minute, 1*time.Minute)
+	r.orphan.firstSeen = map[string]time.Time{}
+	r.skipped.reasons = map[string]string{}
+	r.plan.reports = map[string]*PlanReport{}
+	r.ctx, r.ctxCancel = context.WithCancel(context.Background())
 
 	r.nodeList = &k8s.NodeList{
-		NodeLabelSelector: r.Config.K8S.NodeLabelSelector,
-		AzureClient:       r.azureClient,
-		Client:            r.k8sClient,
-		UserAgent:         r.UserAgent,
-		Logger:            r.Logger,
+		NodeLabelSelector:     r.Config.K8S.NodeLabelSelector,
+		AzureCacheTimeout:     &r.Config.Azure.CacheTtl,
+		AzureInventoryBackend: r.Config.Azure.InventoryBackend,
+		AzureClient:           r.azureClient,
+		Client:                r.k8sClient,
+		UserAgent:             r.UserAgent,
+		Logger:                r.Logger,
+		OnAzureCacheRefresh:   r.azureInventoryRefreshed,
+		OnNodeDelete:          r.nodeDeleted,
+		OnNodePatch:           r.auditNodePatch,
 	}
 
+	r.initVolumeAttachmentInformer()
+	r.initSmokeTest()
+	r.initNotifications()
+	r.initWebhook()
+
+	if r.Config.Repair.EventDriven {
+		r.initRepairEvents()
+	}
+
+	r.initRepairAlerts()
+
 	r.Config.Repair.ProvisioningStateAll = false
 	for key, val := range r.Config.Repair.ProvisioningState {
 		val = strings.ToLower(val)
@@ -179,7 +343,7 @@ func (r *AzureK8sAutopilot) initMetricsGeneral() {
 			Name: "autopilot_errors",
 			Help: "azure_k8s_autopilot error counter",
 		},
-		[]string{"scope"},
+		[]string{"scope", "class"},
 	)
 	prometheus.MustRegister(r.prometheus.general.errors)
 
@@ -201,6 +365,57 @@ func (r *AzureK8sAutopilot) initMetricsGeneral() {
 	)
 
 	prometheus.MustRegister(r.prometheus.general.candidateNodes)
+
+	r.prometheus.general.skippedNodes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_skipped_nodes",
+			Help: "azure_k8s_autopilot count of nodes which were skipped because of Azure policies",
+		},
+		[]string{"type", "reason"},
+	)
+	prometheus.MustRegister(r.prometheus.general.skippedNodes)
+}
+
+func (r *AzureK8sAutopilot) initMetricsAzureInventory() {
+	r.prometheus.azureInventory.duration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autopilot_azure_inventory_duration",
+			Help: "azure_k8s_autopilot duration of last Azure inventory refresh",
+		},
+		[]string{"backend"},
+	)
+	prometheus.MustRegister(r.prometheus.azureInventory.duration)
+
+	r.prometheus.azureInventory.requests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_azure_inventory_requests",
+			Help: "azure_k8s_autopilot count of Azure API requests for inventory refresh",
+		},
+		[]string{"backend"},
+	)
+	prometheus.MustRegister(r.prometheus.azureInventory.requests)
+
+	r.prometheus.azureInventory.resources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autopilot_azure_inventory_resources",
+			Help: "azure_k8s_autopilot count of Azure resources in inventory",
+		},
+		[]string{"backend"},
+	)
+	prometheus.MustRegister(r.prometheus.azureInventory.resources)
+}
+
+func (r *AzureK8sAutopilot) azureInventoryRefreshed(stats k8s.AzureCacheRefreshStats, err error) {
+	r.prometheus.azureInventory.duration.WithLabelValues(stats.Backend).Set(stats.Duration.Seconds())
+	r.prometheus.azureInventory.requests.WithLabelValues(stats.Backend).Add(float64(stats.Requests))
+
+	if class := r.azureHandleError(r.Logger, "azureInventory", err); class != "" {
+		r.Logger.Error("unable to refresh azure inventory", slog.String("errorClass", class), slog.Any("error", err))
+		return
+	}
+
+	r.prometheus.azureInventory.resources.WithLabelValues(stats.Backend).Set(float64(stats.Resources))
+	r.Logger.Debug("refreshed azure inventory", slog.String("backend", stats.Backend), slog.Duration("duration", stats.Duration), slog.Int("requests", stats.Requests), slog.Int("resources", stats.Resources))
 }
 
 func (r *AzureK8sAutopilot) initMetricsRepair() {
@@ -230,6 +445,15 @@ func (r *AzureK8sAutopilot) initMetricsRepair() {
 		[]string{},
 	)
 	prometheus.MustRegister(r.prometheus.repair.duration)
+
+	r.prometheus.repair.inflight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autopilot_repair_inflight",
+			Help: "azure_k8s_autopilot count of currently running repairs",
+		},
+		[]string{},
+	)
+	prometheus.MustRegister(r.prometheus.repair.inflight)
 }
 
 func (r *AzureK8sAutopilot) initMetricsUpdate() {
@@ -250,6 +474,44 @@ func (r *AzureK8sAutopilot) initMetricsUpdate() {
 		[]string{},
 	)
 	prometheus.MustRegister(r.prometheus.update.duration)
+
+	r.prometheus.update.deferredNodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autopilot_update_deferred_nodes",
+			Help: "azure_k8s_autopilot nodes where update is deferred by pod drain annotations (value is deferral start time)",
+		},
+		[]string{"node"},
+	)
+	prometheus.MustRegister(r.prometheus.update.deferredNodes)
+
+	r.prometheus.update.blockingPods = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autopilot_update_blocking_pods",
+			Help: "azure_k8s_autopilot count of pods deferring node update by drain annotations",
+		},
+		[]string{"node", "namespace", "reason"},
+	)
+	prometheus.MustRegister(r.prometheus.update.blockingPods)
+}
+
+func (r *AzureK8sAutopilot) initMetricsOrphan() {
+	r.prometheus.orphan.count = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_orphan_count",
+			Help: "azure_k8s_autopilot orphaned VMSS instance action counter",
+		},
+		[]string{},
+	)
+	prometheus.MustRegister(r.prometheus.orphan.count)
+
+	r.prometheus.orphan.duration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autopilot_orphan_duration",
+			Help: "azure_k8s_autopilot orphaned VMSS instance check duration",
+		},
+		[]string{},
+	)
+	prometheus.MustRegister(r.prometheus.orphan.duration)
 }
 
 func (r *AzureK8sAutopilot) Start() {
@@ -259,6 +521,14 @@ func (r *AzureK8sAutopilot) Start() {
 
 		r.nodeList.Start()
 
+		if r.Config.Repair.EventDriven {
+			r.startAutopilotRepairEvents()
+		}
+
+		if r.AlertmanagerEnabled() {
+			r.startAutopilotRepairAlerts()
+		}
+
 		if r.Config.Repair.Crontab != "" {
 			r.startAutopilotRepair()
 		}
@@ -266,10 +536,23 @@ func (r *AzureK8sAutopilot) Start() {
 		if r.Config.Update.Crontab != "" {
 			r.startAutopilotUpdate()
 		}
+
+		if r.Config.Orphan.Crontab != "" {
+			r.startAutopilotOrphan()
+		}
+
+		if r.Config.Notification.DigestInterval > 0 {
+			r.startNotificationDigest()
+		}
 	}()
 }
 
 func (r *AzureK8sAutopilot) Stop() {
+	// stop accepting new work, no work is added to the WaitGroup after this point
+	r.workLock.Lock()
+	r.stopping.Store(true)
+	r.workLock.Unlock()
+
 	if r.cron.repair != nil {
 		r.cron.repair.Stop()
 	}
@@ -278,8 +561,57 @@ func (r *AzureK8sAutopilot) Stop() {
 		r.cron.update.Stop()
 	}
 
-	r.wg.Wait()
+	if r.cron.orphan != nil {
+		r.cron.orphan.Stop()
+	}
+
+	if r.repair.queue != nil {
+		r.repair.queue.ShutDown()
+	}
+
+	// give ongoing actions time to finish
+	finished := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(finished)
+	}()
+
+	actionsStopped := true
+	select {
+	case <-finished:
+		r.Logger.Info("all ongoing actions finished")
+	case <-time.After(r.Config.Shutdown.GracePeriod):
+		// cancel ongoing actions, they will persist their state to the node annotations
+		r.Logger.Warn("shutdown grace period exceeded, cancelling ongoing actions", slog.Duration("gracePeriod", r.Config.Shutdown.GracePeriod))
+		r.repairCancelInflight()
+		r.ctxCancel()
+
+		select {
+		case <-finished:
+			r.Logger.Info("all ongoing actions stopped")
+		case <-time.After(shutdownCancelTimeout):
+			r.Logger.Warn("ongoing actions not stopped after cancellation, not waiting anymore", slog.Duration("timeout", shutdownCancelTimeout))
+			actionsStopped = false
+		}
+	}
+	r.ctxCancel()
+
+	// send pending digest events of finished actions
+	r.stopNotificationDigest()
+
+	// send pending lifecycle events
+	r.stopWebhook()
+
 	r.nodeList.Stop()
+	r.shutdownAudit()
+
+	// another instance must not start while actions are still running, the lock is removed with the pod then
+	if actionsStopped {
+		r.leaderRelease()
+	} else {
+		r.Logger.Warn("ongoing actions not stopped, leader lock is released when pod is removed")
+	}
+	r.shutdownTracing()
 }
 
 func (r *AzureK8sAutopilot) startAutopilotRepair() {
@@ -295,21 +627,38 @@ func (r *AzureK8sAutopilot) startAutopilotRepair() {
 	)
 
 	_, err := r.cron.repair.AddFunc(r.Config.Repair.Crontab, func() {
-		r.wg.Add(1)
+		if !r.workStart() {
+			return
+		}
 		defer r.wg.Done()
 
 		contextLogger := r.Logger.With(slog.String("job", "repair"))
 
-		// update node lock cache
-		r.syncNodeLockCache(contextLogger, r.nodeList.NodeList(), r.Config.Repair.NodeLockAnnotation, r.repair.nodeLock)
+		r.repair.lock.Lock()
+		defer r.repair.lock.Unlock()
+
+		// expired locks and lock cache
+		r.repairSyncLocks(contextLogger)
 
 		// concurrency repair limit
-		if r.Config.Repair.Limit > 0 && r.repair.nodeLock.ItemCount() >= r.Config.Repair.Limit {
+		if r.Config.Repair.Limit > 0 && r.repairActiveCount() >= r.Config.Repair.Limit {
 			contextLogger.Infof("concurrent repair limit reached, skipping run")
+			plan := newPlanReport("repair", AuditTriggerCron, r.Config.DryRun)
+			plan.check("limit", true, "%v of %v repairs active", r.repairActiveCount(), r.Config.Repair.Limit)
+			r.planFinish(contextLogger, plan)
+		} else if throttled, until := r.azureIsThrottled(); throttled {
+			contextLogger.Info("Azure API throttling detected, skipping run", slog.Time("pausedUntil", until))
+			plan := newPlanReport("repair", AuditTriggerCron, r.Config.DryRun)
+			plan.check("azureThrottling", true, "paused until %v", until.Format(time.RFC3339))
+			r.planFinish(contextLogger, plan)
 		} else {
 			start := time.Now()
 			contextLogger.Info("starting repair check")
-			r.repairRun(contextLogger)
+			ctx, span := tracer.Start(auditContext(r.ctx, "repair", AuditTriggerCron), "repair.run")
+			defer span.End()
+			ctx, cancel := contextWithOptionalTimeout(ctx, r.Config.Repair.RunTimeout)
+			defer cancel()
+			r.repairRun(ctx, contextLogger)
 			runtime := time.Since(start)
 			r.prometheus.repair.duration.WithLabelValues().Set(runtime.Seconds())
 			contextLogger.With(slog.Float64("duration", runtime.Seconds())).Infof("finished after %s", runtime.String())
@@ -334,24 +683,39 @@ func (r *AzureK8sAutopilot) startAutopilotUpdate() {
 	)
 
 	_, err := r.cron.update.AddFunc(r.Config.Update.Crontab, func() {
-		r.wg.Add(1)
+		if !r.workStart() {
+			return
+		}
 		defer r.wg.Done()
 
 		contextLogger := r.Logger.With(slog.String("job", "update"))
+		lockCtx := auditContext(r.ctx, "update", AuditTriggerLockExpired)
 
 		// automatic remove cordon state on nodes
-		r.autoUncordonExpiredNodes(contextLogger, r.nodeList.NodeList(), r.Config.Update.NodeLockAnnotation)
+		r.autoUncordonExpiredNodes(lockCtx, contextLogger, r.nodeList.NodeList(), r.Config.Update.NodeLockAnnotation)
 
 		// update node lock cache
-		r.syncNodeLockCache(contextLogger, r.nodeList.NodeList(), r.Config.Update.NodeLockAnnotation, r.update.nodeLock)
+		r.syncNodeLockCache(lockCtx, contextLogger, r.nodeList.NodeList(), r.Config.Update.NodeLockAnnotation, r.update.nodeLock)
 
 		// concurrency repair limit
 		if r.Config.Update.Limit > 0 && r.update.nodeLock.ItemCount() >= r.Config.Update.Limit {
 			contextLogger.Infof("concurrent update limit reached, skipping run")
+			plan := newPlanReport("update", AuditTriggerCron, r.Config.DryRun)
+			plan.check("limit", true, "%v of %v updates active", r.update.nodeLock.ItemCount(), r.Config.Update.Limit)
+			r.planFinish(contextLogger, plan)
+		} else if throttled, until := r.azureIsThrottled(); throttled {
+			contextLogger.Info("Azure API throttling detected, skipping run", slog.Time("pausedUntil", until))
+			plan := newPlanReport("update", AuditTriggerCron, r.Config.DryRun)
+			plan.check("azureThrottling", true, "paused until %v", until.Format(time.RFC3339))
+			r.planFinish(contextLogger, plan)
 		} else {
 			contextLogger.Info("starting update check")
 			start := time.Now()
-			r.updateRun(contextLogger)
+			ctx, span := tracer.Start(auditContext(r.ctx, "update", AuditTriggerCron), "update.run")
+			defer span.End()
+			ctx, cancel := contextWithOptionalTimeout(ctx, r.Config.Update.Timeout)
+			defer cancel()
+			r.updateRun(ctx, contextLogger)
 			runtime := time.Since(start)
 			r.prometheus.update.duration.WithLabelValues().Set(runtime.Seconds())
 			contextLogger.With(slog.Float64("duration", runtime.Seconds())).Infof("finished after %s", runtime.String())
@@ -364,6 +728,48 @@ func (r *AzureK8sAutopilot) startAutopilotUpdate() {
 	r.cron.update.Start()
 }
 
+func (r *AzureK8sAutopilot) startAutopilotOrphan() {
+	r.cron.orphan = cron.New(
+		cron.WithChain(
+			cron.SkipIfStillRunning(
+				cron.PrintfLogger(
+					&AzureK8sAutopilotLogger{logger: r.Logger},
+				),
+			),
+		),
+	)
+
+	_, err := r.cron.orphan.AddFunc(r.Config.Orphan.Crontab, func() {
+		if !r.workStart() {
+			return
+		}
+		defer r.wg.Done()
+
+		contextLogger := r.Logger.With(slog.String("job", "orphan"))
+
+		if throttled, until := r.azureIsThrottled(); throttled {
+			contextLogger.Info("Azure API throttling detected, skipping run", slog.Time("pausedUntil", until))
+			plan := newPlanReport("orphan", AuditTriggerCron, r.Config.DryRun)
+			plan.check("azureThrottling", true, "paused until %v", until.Format(time.RFC3339))
+			r.planFinish(contextLogger, plan)
+		} else {
+			contextLogger.Info("starting orphaned VMSS instance check")
+			start := time.Now()
+			ctx, span := tracer.Start(auditContext(r.ctx, "orphan", AuditTriggerCron), "orphan.run")
+			r.orphanRun(ctx, contextLogger)
+			span.End()
+			runtime := time.Since(start)
+			r.prometheus.orphan.duration.WithLabelValues().Set(runtime.Seconds())
+			contextLogger.With(slog.Float64("duration", runtime.Seconds())).Infof("finished after %s", runtime.String())
+		}
+	})
+	if err != nil {
+		r.Logger.Panic(err.Error())
+	}
+
+	r.cron.orphan.Start()
+}
+
 func (r *AzureK8sAutopilot) leaderElect() {
 	if r.Config.Lease.Enabled {
 		r.Logger.Info("starting leader election")
@@ -380,10 +786,79 @@ func (r *AzureK8sAutopilot) leaderElect() {
 			r.Logger.Error("failed to retry for leader lock", slog.Any("error", err))
 			os.Exit(1)
 		}
+		r.isLeader.Store(true)
 		r.Logger.Info("acquired leader lock, continue")
 	}
 }
 
+// release leader lock by removing the lock ConfigMap, only if it is owned by this pod (otherwise removed by garbage collection)
+func (r *AzureK8sAutopilot) leaderRelease() {
+	if !r.isLeader.Load() {
+		return
+	}
+
+	namespace := r.instanceNamespace()
+	podName := os.Getenv("POD_NAME")
+	if namespace == "" || podName == "" {
+		r.Logger.Warn("unable to detect namespace or pod name, leader lock is released when pod is removed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	configMap, err := r.k8sClient.CoreV1().ConfigMaps(namespace).Get(ctx, r.Config.Lease.Name, metav1.GetOptions{})
+	if err != nil {
+		r.Logger.Error("unable to fetch leader lock", slog.Any("error", err))
+		return
+	}
+
+	var owner *metav1.OwnerReference
+	for i, ownerRef := range configMap.OwnerReferences {
+		if ownerRef.Kind == "Pod" && ownerRef.Name == podName {
+			owner = &configMap.OwnerReferences[i]
+		}
+	}
+	if owner == nil {
+		r.Logger.Warn("leader lock is not owned by this pod, not releasing it", slog.String("namespace", namespace), slog.String("lease", r.Config.Lease.Name))
+		r.isLeader.Store(false)
+		return
+	}
+
+	// lock must not be replaced in the meantime
+	deleteOpts := metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &configMap.UID, ResourceVersion: &configMap.ResourceVersion},
+	}
+
+	r.Logger.Info("releasing leader lock", slog.String("namespace", namespace), slog.String("lease", r.Config.Lease.Name))
+	if err := r.k8sClient.CoreV1().ConfigMaps(namespace).Delete(ctx, r.Config.Lease.Name, deleteOpts); err != nil {
+		r.Logger.Error("unable to release leader lock", slog.Any("error", err))
+	}
+	r.isLeader.Store(false)
+}
+
+// register ongoing work in the WaitGroup, returns false if shutdown was started (caller must call wg.Done otherwise)
+func (r *AzureK8sAutopilot) workStart() bool {
+	r.workLock.Lock()
+	defer r.workLock.Unlock()
+
+	if r.stopping.Load() {
+		return false
+	}
+	r.wg.Add(1)
+	return true
+}
+
+// namespace of autopilot pod (from options or service account)
+func (r *AzureK8sAutopilot) instanceNamespace() string {
+	if r.Config.Instance.Namespace != nil {
+		return *r.Config.Instance.Namespace
+	} else if content, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		return strings.TrimSpace(string(content))
+	}
+	return ""
+}
+
 func (r *AzureK8sAutopilot) checkSelfEviction(node *k8s.Node) bool {
 	if r.Config.Instance.Nodename == nil || r.Config.Instance.Namespace == nil || r.Config.Instance.Pod == nil {
 		return false
@@ -415,22 +890,7 @@ func (r *AzureK8sAutopilot) checkSelfEviction(node *k8s.Node) bool {
 	return false
 }
 
-func (r *AzureK8sAutopilot) sendNotificationf(message string, args ...interface{}) {
-	r.sendNotification(fmt.Sprintf(message, args...))
-}
-
-func (r *AzureK8sAutopilot) sendNotification(message string) {
-	sender, err := shoutrrr.CreateSender(r.Config.Notification...)
-	if err != nil {
-		r.Logger.Errorf("Unable to send shoutrrr notification: %v", err.Error())
-	}
-
-	if sender != nil {
-		sender.Send(message, nil)
-	}
-}
-
-func (r *AzureK8sAutopilot) syncNodeLockCache(contextLogger *slogger.Logger, nodeList []*k8s.Node, annotationName string, cacheLock *cache.Cache) {
+func (r *AzureK8sAutopilot) syncNodeLockCache(ctx context.Context, contextLogger *slogger.Logger, nodeList []*k8s.Node, annotationName string, cacheLock *cache.Cache) {
 	// lock cache clear
 	contextLogger.Debug("sync node lock cache for annotation", slog.String("annotation", annotationName))
 	cacheLock.Flush()
@@ -439,9 +899,14 @@ func (r *AzureK8sAutopilot) syncNodeLockCache(contextLogger *slogger.Logger, nod
 		if lockDuration, exists := node.AnnotationLockCheck(annotationName); exists {
 			// check if annotation is valid and if node status is ok
 			if lockDuration == nil || lockDuration.Seconds() <= 0 {
+				if r.Config.DryRun {
+					contextLogger.Debug("expired lock annotation on node, not removing it (dry run)", slog.String("annotation", annotationName), slog.String("node", node.Name))
+					continue
+				}
+
 				// remove annotation
 				contextLogger.Debug("removing lock annotation from node", slog.String("annotation", annotationName), slog.String("node", node.Name))
-				if err := node.AnnotationLockRemove(annotationName); err != nil {
+				if err := node.AnnotationLockRemove(ctx, annotationName); err != nil {
 					contextLogger.Error(err.Error())
 				}
 				continue
@@ -461,7 +926,18 @@ func (r *AzureK8sAutopilot) syncNodeLockCache(contextLogger *slogger.Logger, nod
 	cacheLock.DeleteExpired()
 }
 
-func (r *AzureK8sAutopilot) autoUncordonExpiredNodes(contextLogger *slogger.Logger, nodeList []*k8s.Node, annotationName string) {
+// cleanup state of deleted nodes
+func (r *AzureK8sAutopilot) nodeDeleted(nodeName string) {
+	r.metricsNodeDeleted(nodeName)
+	r.notificationNodeDeleted(nodeName)
+}
+
+// checks if verification (warm-up, smoke test) of node failed, node must stay cordoned until the annotation is removed
+func (r *AzureK8sAutopilot) nodeVerificationFailed(node *k8s.Node) bool {
+	return node.AnnotationExists(r.Config.Update.Warmup.FailedAnnotation) || node.AnnotationExists(r.Config.SmokeTest.FailedAnnotation)
+}
+
+func (r *AzureK8sAutopilot) autoUncordonExpiredNodes(ctx context.Context, contextLogger *slogger.Logger, nodeList []*k8s.Node, annotationName string) {
 	// lock cache clear
 	contextLogger.Debugf("checking expired but still cordoned nodes for annotation \"%s\"", annotationName)
 
@@ -469,12 +945,22 @@ func (r *AzureK8sAutopilot) autoUncordonExpiredNodes(contextLogger *slogger.Logg
 		if lockDuration, exists := node.AnnotationLockCheck(annotationName); exists {
 			// check if annotation is valid and if node status is ok
 			if lockDuration == nil || lockDuration.Seconds() <= 0 {
-				// check if node is cordoned
+				// check if node is cordoned, nodes with failed verification stay cordoned
 				if node.Spec.Unschedulable {
+					if r.nodeVerificationFailed(node) {
+						contextLogger.Info("node is still cordoned, verification failed, not uncordoning it", slog.String("node", node.Name))
+						continue
+					}
+
+					if r.Config.DryRun {
+						contextLogger.Info("node is still cordoned, uncordon skipped (dry run)", slog.String("node", node.Name))
+						continue
+					}
+
 					contextLogger.Info("node is still cordoned, uncording it", slog.String("node", node.Name))
 
 					// uncordon node
-					if err := r.k8sUncordonNode(contextLogger, node); err != nil {
+					if err := r.k8sUncordonNode(ctx, contextLogger, node); err != nil {
 						contextLogger.Error("node uncordon failed", slog.String("node", node.Name), slog.Any("error", err))
 					}
 				}
@@ -1,5 +1,10 @@
 package autopilot
 
+import (
+	"context"
+	"time"
+)
+
 func stringArrayContains(arr []string, needle string) bool {
 	for _, val := range arr {
 		if val == needle {
@@ -9,3 +14,11 @@ func stringArrayContains(arr []string, needle string) bool {
 
 	return false
 }
+
+// returns context with timeout, zero timeout means no timeout
+func contextWithOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
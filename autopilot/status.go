@@ -0,0 +1,15 @@
+package autopilot
+
+type (
+	// Status of autopilot (exposed by /status)
+	Status struct {
+		DeferredNodes []updateDeferral `json:"deferredNodes"`
+	}
+)
+
+// Status returns current status of autopilot
+func (r *AzureK8sAutopilot) Status() Status {
+	return Status{
+		DeferredNodes: r.updateDeferrals(),
+	}
+}
@@ -0,0 +1,378 @@
+package autopilot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/webdevops/go-common/log/slogger"
+	"github.com/webdevops/go-common/utils/to"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+type (
+	// VMSS instance without K8s node
+	orphanInstance struct {
+		vmssInfo   k8s.NodeInfo
+		instanceID string
+		resourceID string
+
+		// creation (VMSS Flex) or last provisioning time (VMSS) of instance
+		created *time.Time
+
+		protectFromScaleIn         bool
+		protectFromScaleSetActions bool
+	}
+)
+
+func (r *AzureK8sAutopilot) orphanRun(ctx context.Context, contextLogger *slogger.Logger) {
+	nodeList, err := r.nodeList.NodeListWithAzure()
+	if err != nil {
+		contextLogger.Errorf("unable to fetch K8s Node list: %s", err.Error())
+		return
+	}
+
+	// all registered nodes (not filtered by label selector), instances of other nodes are not orphaned
+	allNodeList, err := r.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		contextLogger.Errorf("unable to fetch K8s Node list: %s", err.Error())
+		return
+	}
+
+	nodeProviderIDs := map[string]bool{}
+	for _, node := range allNodeList.Items {
+		nodeProviderIDs[strings.ToLower(strings.TrimPrefix(node.Spec.ProviderID, "azure://"))] = true
+	}
+
+	// scale sets of managed nodes
+	scaleSetList := map[string]k8s.NodeInfo{}
+	for _, node := range nodeList {
+		nodeInfo, err := k8s.ExtractNodeInfo(node)
+		if err != nil {
+			contextLogger.Error(err.Error())
+			continue
+		}
+
+		if nodeInfo.IsVmss {
+			scaleSetKey := strings.ToLower(fmt.Sprintf("%s/%s/%s", nodeInfo.VMScaleSetSubscription, nodeInfo.VMScaleSetResourceGroup, nodeInfo.VMScaleSetName))
+			scaleSetList[scaleSetKey] = *nodeInfo
+		}
+	}
+
+	// find instances which are not registered as node
+	orphanList := []*orphanInstance{}
+	seenInstances := map[string]bool{}
+	for _, vmssInfo := range scaleSetList {
+		instanceList, err := r.orphanListScaleSetInstances(ctx, vmssInfo)
+		if err != nil {
+			r.azureHandleError(contextLogger, "orphan", err)
+			contextLogger.Error("unable to list VMSS instances", slog.String("vmss", vmssInfo.VMScaleSetName), slog.Any("error", err))
+			continue
+		}
+
+		for _, instance := range instanceList {
+			seenInstances[instance.resourceID] = true
+			if !nodeProviderIDs[instance.resourceID] {
+				orphanList = append(orphanList, instance)
+			}
+		}
+	}
+
+	// forget instances which are gone (or registered in the meantime)
+	r.orphan.lock.Lock()
+	for resourceID := range r.orphan.firstSeen {
+		if !seenInstances[resourceID] || nodeProviderIDs[resourceID] {
+			delete(r.orphan.firstSeen, resourceID)
+		}
+	}
+	for _, instance := range orphanList {
+		if _, exists := r.orphan.firstSeen[instance.resourceID]; !exists {
+			r.orphan.firstSeen[instance.resourceID] = time.Now()
+		}
+	}
+	r.orphan.lock.Unlock()
+
+	contextLogger.Infof("found %v VMSS instances without K8s node", len(orphanList))
+	r.prometheus.general.candidateNodes.WithLabelValues("orphan").Set(float64(len(orphanList)))
+
+	plan := newPlanReport("orphan", AuditTriggerCron, r.Config.DryRun)
+	defer r.planFinish(contextLogger, plan)
+
+	// skipped instances which are gone are counted again if they reappear
+	orphanNames := map[string]bool{}
+	for _, instance := range orphanList {
+		orphanNames[orphanInstanceName(instance)] = true
+	}
+	r.metricsNodeSkipPrune("orphan", orphanNames)
+
+	// oldest first
+	sort.Slice(orphanList, func(i, j int) bool {
+		return r.orphanSince(orphanList[i]).Before(r.orphanSince(orphanList[j]))
+	})
+
+	count := 0
+	for _, instance := range orphanList {
+		instanceLogger := contextLogger.With(
+			slog.String("vmss", instance.vmssInfo.VMScaleSetName),
+			slog.String("vmssInstance", instance.instanceID),
+		)
+
+		instancePlan := plan.node(orphanInstanceName(instance))
+		instancePlan.check("registeredAsNode", false, "no K8s node for instance")
+
+		// grace period for booting instances
+		since := r.orphanSince(instance)
+		gracePeriodText := fmt.Sprintf("created %v (grace period %v)", since.Format(time.RFC3339), r.Config.Orphan.GracePeriod)
+		if instance.created == nil {
+			gracePeriodText = fmt.Sprintf("first seen %v (grace period %v)", since.Format(time.RFC3339), r.Config.Orphan.GracePeriod)
+		}
+		if time.Since(since) < r.Config.Orphan.GracePeriod {
+			instanceLogger.Info("detected VMSS instance without K8s node, grace period not reached yet", slog.Time("since", since), slog.Duration("gracePeriod", r.Config.Orphan.GracePeriod))
+			instancePlan.check("gracePeriod", true, "%v", gracePeriodText)
+			instancePlan.decide(PlanDecisionWait, r.Config.Orphan.AzureVmssAction, "grace period not reached yet")
+			continue
+		}
+		instancePlan.check("gracePeriod", false, "%v", gracePeriodText)
+
+		// instance was already handled
+		if _, expiry, exists := r.orphan.instanceLock.GetWithExpiration(instance.resourceID); exists {
+			instanceLogger.Info("detected VMSS instance without K8s node, still locked", slog.Time("lockTime", expiry))
+			instancePlan.check("lock", true, "locked until %v", expiry.Format(time.RFC3339))
+			instancePlan.decide(PlanDecisionSkip, r.Config.Orphan.AzureVmssAction, "instance is locked")
+			continue
+		}
+		instancePlan.check("lock", false, "not locked")
+
+		// VMSS instance protection policy
+		protected := !r.azurePolicyCheckProtectionPolicy(instanceLogger, "orphan", instancePlan.Node, instance.protectFromScaleIn, instance.protectFromScaleSetActions, r.Config.Orphan.AzureVmssAction, r.Config.Orphan.AzureVmssProtection)
+		instancePlan.check("protectionPolicy", protected, "protectFromScaleIn=%v protectFromScaleSetActions=%v (handling %v)", instance.protectFromScaleIn, instance.protectFromScaleSetActions, r.Config.Orphan.AzureVmssProtection)
+		if protected {
+			instancePlan.decide(PlanDecisionSkip, r.Config.Orphan.AzureVmssAction, "Azure VMSS instance protection policy")
+			continue
+		}
+
+		// rate limit
+		if r.Config.Orphan.Limit > 0 {
+			if count >= r.Config.Orphan.Limit {
+				instanceLogger.Info("detected VMSS instance without K8s node, skipping due to limit")
+				r.metricsNodeSkipped("orphan", instancePlan.Node, r.Config.Orphan.AzureVmssAction, "limit")
+				instancePlan.check("limit", true, "%v of %v instances handled in this run", count, r.Config.Orphan.Limit)
+				instancePlan.decide(PlanDecisionSkip, r.Config.Orphan.AzureVmssAction, "limit reached")
+				continue
+			}
+			instancePlan.check("limit", false, "%v of %v instances handled in this run", count, r.Config.Orphan.Limit)
+		}
+		count++
+
+		instancePlan.decide(PlanDecisionAction, r.Config.Orphan.AzureVmssAction, "VMSS instance is not registered as K8s node")
+		if r.Config.DryRun {
+			instanceLogger.Info("orphaned VMSS instance action skipped, dry run", slog.String("action", r.Config.Orphan.AzureVmssAction))
+			r.metricsNodeSkipped("orphan", instancePlan.Node, r.Config.Orphan.AzureVmssAction, "dry-run")
+			continue
+		}
+
+		r.metricsNodeSkipReset("orphan", instancePlan.Node)
+		r.orphanHandle(ctx, instanceLogger, instance)
+	}
+}
+
+// name of VMSS instance (vmss/instanceID) for plan and metrics
+func orphanInstanceName(instance *orphanInstance) string {
+	return fmt.Sprintf("%s/%s", instance.vmssInfo.VMScaleSetName, instance.instanceID)
+}
+
+func (r *AzureK8sAutopilot) orphanFirstSeen(instance *orphanInstance) time.Time {
+	r.orphan.lock.Lock()
+	defer r.orphan.lock.Unlock()
+	return r.orphan.firstSeen[instance.resourceID]
+}
+
+// start of grace period, creation time of instance (first seen by autopilot if unknown)
+func (r *AzureK8sAutopilot) orphanSince(instance *orphanInstance) time.Time {
+	if instance.created != nil {
+		return *instance.created
+	}
+	return r.orphanFirstSeen(instance)
+}
+
+func (r *AzureK8sAutopilot) orphanLock(contextLogger *slogger.Logger, instance *orphanInstance) {
+	if err := r.orphan.instanceLock.Add(instance.resourceID, true, r.Config.Orphan.LockDuration); err != nil {
+		contextLogger.Error(err.Error())
+	}
+}
+
+// trigger Azure action for orphaned VMSS instance
+func (r *AzureK8sAutopilot) orphanHandle(ctx context.Context, contextLogger *slogger.Logger, instance *orphanInstance) {
+	ctx, span := tracer.Start(ctx, "orphan.instance", trace.WithAttributes(
+		attributeVmss.String(instance.vmssInfo.VMScaleSetName),
+		attribute.String("azure.vmss.instance_id", instance.instanceID),
+		attributeAction.String(r.Config.Orphan.AzureVmssAction),
+	))
+	defer span.End()
+
+	r.prometheus.orphan.count.WithLabelValues().Inc()
+	r.orphanLock(contextLogger, instance)
+
+	startTime := time.Now()
+	notification := NotificationEvent{
+		Event:  NotificationEventOrphanStarted,
+		Pool:   instance.vmssInfo.VMScaleSetName,
+		Action: r.Config.Orphan.AzureVmssAction,
+	}
+
+	contextLogger.Info("detected VMSS instance without K8s node, starting action", slog.String("action", r.Config.Orphan.AzureVmssAction))
+	notification.Message = fmt.Sprintf("trigger automatic %v of VMSS instance %v/%v (not registered as K8s node)", r.Config.Orphan.AzureVmssAction, instance.vmssInfo.VMScaleSetName, instance.instanceID)
+	r.notify(notification)
+
+	// orphaned instances have no K8s node
+	instanceInfo := k8s.NodeInfo{
+		ProviderId:              instance.resourceID,
+		Subscription:            instance.vmssInfo.Subscription,
+		ResourceGroup:           instance.vmssInfo.ResourceGroup,
+		IsVmss:                  true,
+		IsVmssFlex:              instance.vmssInfo.IsVmssFlex,
+		VMScaleSetName:          instance.vmssInfo.VMScaleSetName,
+		VMScaleSetSubscription:  instance.vmssInfo.VMScaleSetSubscription,
+		VMScaleSetResourceGroup: instance.vmssInfo.VMScaleSetResourceGroup,
+		VMInstanceID:            instance.instanceID,
+	}
+	target := &nodeTarget{info: &instanceInfo, action: r.Config.Orphan.AzureVmssAction}
+	target.setInput("registeredAsNode", false)
+	target.setInput("firstSeen", r.orphanFirstSeen(instance))
+	if instance.created != nil {
+		target.setInput("created", *instance.created)
+	}
+	target.setInput("gracePeriod", r.Config.Orphan.GracePeriod.String())
+	r.lifecycleEvent("orphan", LifecyclePhaseDetected, "", r.Config.Orphan.AzureVmssAction, target, nil)
+	r.lifecycleEvent("orphan", LifecyclePhaseLocked, "", r.Config.Orphan.AzureVmssAction, target, nil)
+
+	instanceIDs := []*string{&instance.instanceID}
+	azureCtx := azureCorrelationContext(ctx, target)
+	r.lifecycleEvent("orphan", LifecyclePhaseAzureAction, LifecycleStatusStarted, r.Config.Orphan.AzureVmssAction, target, nil)
+	err := r.azureVmssInstancesAction(azureCtx, contextLogger, instanceInfo, instanceIDs, r.Config.Orphan.AzureVmssAction)
+	r.metricsAzureOperation("orphan", r.Config.Orphan.AzureVmssAction, target, startTime, err)
+	r.prometheus.operation.actions.WithLabelValues("orphan", r.Config.Orphan.AzureVmssAction, metricsResult(err), "").Inc()
+	r.lifecycleEvent("orphan", LifecyclePhaseAzureAction, lifecycleStatus(err), r.Config.Orphan.AzureVmssAction, target, err)
+	if err != nil {
+		errorClass := azureErrorClass(err)
+		contextLogger.Error("orphaned VMSS instance action failed", slog.String("errorClass", errorClass), slog.Any("error", err))
+		notification.Event = NotificationEventOrphanFailed
+		notification.Message = fmt.Sprintf("automatic %v of VMSS instance %v/%v failed (%v): %v", r.Config.Orphan.AzureVmssAction, instance.vmssInfo.VMScaleSetName, instance.instanceID, errorClass, err)
+		notification.Error = err.Error()
+		notification.Duration = time.Since(startTime).Round(time.Second)
+		r.notify(notification)
+		return
+	}
+
+	contextLogger.Info("orphaned VMSS instance action successful")
+	notification.Event = NotificationEventOrphanSucceeded
+	notification.Message = fmt.Sprintf("automatic %v of VMSS instance %v/%v succeeded", r.Config.Orphan.AzureVmssAction, instance.vmssInfo.VMScaleSetName, instance.instanceID)
+	notification.Duration = time.Since(startTime).Round(time.Second)
+	r.notify(notification)
+}
+
+// list instances of scale set (instances in deleting state are ignored)
+func (r *AzureK8sAutopilot) orphanListScaleSetInstances(ctx context.Context, vmssInfo k8s.NodeInfo) ([]*orphanInstance, error) {
+	ret := []*orphanInstance{}
+
+	addInstance := func(resourceID, instanceID *string, provisioningState *string) *orphanInstance {
+		if resourceID == nil || instanceID == nil {
+			return nil
+		}
+		if provisioningState != nil && strings.EqualFold(*provisioningState, "deleting") {
+			return nil
+		}
+		instance := &orphanInstance{
+			vmssInfo:   vmssInfo,
+			instanceID: *instanceID,
+			resourceID: to.StringLower(resourceID),
+		}
+		ret = append(ret, instance)
+		return instance
+	}
+
+	if vmssInfo.IsVmssFlex {
+		// VMSS Flex instances are VMs, addressed by VM name
+		client, err := armcompute.NewVirtualMachinesClient(vmssInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		// VMs are listed in the resource group of the known instances, the scale set can be in another resource group
+		vmssId := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s", vmssInfo.VMScaleSetSubscription, vmssInfo.VMScaleSetResourceGroup, vmssInfo.VMScaleSetName)
+		listOpts := armcompute.VirtualMachinesClientListOptions{
+			Filter: to.StringPtr(fmt.Sprintf("'virtualMachineScaleSet/id' eq '%s'", vmssId)),
+		}
+		pager := client.NewListPager(vmssInfo.ResourceGroup, &listOpts)
+		for pager.More() {
+			result, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, vm := range result.Value {
+				if vm.Properties == nil {
+					addInstance(vm.ID, vm.Name, nil)
+					continue
+				}
+				if instance := addInstance(vm.ID, vm.Name, vm.Properties.ProvisioningState); instance != nil {
+					instance.created = vm.Properties.TimeCreated
+				}
+			}
+		}
+
+		return ret, nil
+	}
+
+	client, err := armcompute.NewVirtualMachineScaleSetVMsClient(vmssInfo.Subscription, r.azureClient.GetCred(), r.azureClientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	// VMSS instances have no creation time, provisioning time is taken from instance view
+	listOpts := armcompute.VirtualMachineScaleSetVMsClientListOptions{
+		Expand: to.StringPtr("instanceView"),
+	}
+	pager := client.NewListPager(vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, &listOpts)
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, vmssInstance := range result.Value {
+			if vmssInstance.Properties == nil {
+				addInstance(vmssInstance.ID, vmssInstance.InstanceID, nil)
+				continue
+			}
+
+			instance := addInstance(vmssInstance.ID, vmssInstance.InstanceID, vmssInstance.Properties.ProvisioningState)
+			if instance == nil {
+				continue
+			}
+
+			if policy := vmssInstance.Properties.ProtectionPolicy; policy != nil {
+				instance.protectFromScaleIn = policy.ProtectFromScaleIn != nil && *policy.ProtectFromScaleIn
+				instance.protectFromScaleSetActions = policy.ProtectFromScaleSetActions != nil && *policy.ProtectFromScaleSetActions
+			}
+
+			if instanceView := vmssInstance.Properties.InstanceView; instanceView != nil {
+				for _, status := range instanceView.Statuses {
+					if status.Code != nil && strings.HasPrefix(strings.ToLower(*status.Code), "provisioningstate/") && status.Time != nil {
+						instance.created = status.Time
+					}
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
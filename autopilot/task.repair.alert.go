@@ -0,0 +1,202 @@
+package autopilot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+const (
+	repairAlertActionDefault = "default"
+
+	// received webhooks waiting for processing, Alertmanager retries if the queue is full
+	repairAlertQueueSize = 100
+)
+
+type (
+	// Alertmanager webhook payload (https://prometheus.io/docs/alerting/latest/configuration/#webhook_config)
+	alertmanagerPayload struct {
+		Version string              `json:"version"`
+		Status  string              `json:"status"`
+		Alerts  []alertmanagerAlert `json:"alerts"`
+	}
+
+	alertmanagerAlert struct {
+		Status      string            `json:"status"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	}
+)
+
+// parse alert rules (alertname=action)
+func (r *AzureK8sAutopilot) initRepairAlerts() {
+	r.repair.alertRules = map[string]string{}
+	r.repair.alertQueue = make(chan []alertmanagerAlert, repairAlertQueueSize)
+	for _, rule := range r.Config.Repair.Alert.Rules {
+		alertName, action, found := strings.Cut(rule, "=")
+		alertName = strings.TrimSpace(alertName)
+		if !found {
+			action = repairAlertActionDefault
+		}
+
+		if alertName == "" {
+			r.Logger.Panic(fmt.Sprintf("invalid repair alert rule \"%s\", alertname is empty", rule))
+		}
+
+		switch action {
+		case repairAlertActionDefault, "restart", "redeploy", "reimage", "delete":
+		default:
+			r.Logger.Panic(fmt.Sprintf("invalid repair alert rule \"%s\", action %s is not valid", rule, action))
+		}
+
+		r.repair.alertRules[alertName] = action
+	}
+
+	// webhook can reimage or delete nodes, it is not served without authentication
+	if len(r.repair.alertRules) > 0 && r.Config.Repair.Alert.Token == "" {
+		r.Logger.Error("repair alert rules are set but --repair.alert.token is empty, alertmanager webhook is disabled")
+	}
+}
+
+// process received alerts in background, the webhook only enqueues them
+// (each queued webhook is registered in the WaitGroup, so shutdown waits until it is processed)
+func (r *AzureK8sAutopilot) startAutopilotRepairAlerts() {
+	go func() {
+		for alerts := range r.repair.alertQueue {
+			r.repairAlerts(alerts)
+			r.wg.Done()
+		}
+	}()
+}
+
+// AlertmanagerEnabled returns true if alerts can trigger repairs (rules and token are set)
+func (r *AzureK8sAutopilot) AlertmanagerEnabled() bool {
+	return len(r.repair.alertRules) > 0 && r.Config.Repair.Alert.Token != ""
+}
+
+// AlertmanagerHandler receives Alertmanager webhooks and triggers repair of nodes from firing alerts
+func (r *AzureK8sAutopilot) AlertmanagerHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+r.Config.Repair.Alert.Token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// only the leader is doing repairs, Alertmanager will retry the webhook
+	if r.Config.Lease.Enabled && !r.isLeader.Load() {
+		http.Error(w, "not leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	payload := alertmanagerPayload{}
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		http.Error(w, fmt.Sprintf("unable to parse alertmanager payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// shutdown in progress, Alertmanager will retry the webhook (on the new leader)
+	if !r.workStart() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// repairs are waiting for the repair lock, so alerts are processed in background
+	select {
+	case r.repair.alertQueue <- payload.Alerts:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		r.wg.Done()
+		http.Error(w, "alert queue is full", http.StatusServiceUnavailable)
+	}
+}
+
+// trigger repair for firing alerts matching the alert rules, same locks and limits as repairRun apply
+func (r *AzureK8sAutopilot) repairAlerts(alerts []alertmanagerAlert) {
+	contextLogger := r.Logger.With(slog.String("job", "repair"), slog.String("trigger", "alert"))
+
+	r.repair.lock.Lock()
+	defer r.repair.lock.Unlock()
+
+	// expired locks and lock cache
+	r.repairSyncLocks(contextLogger)
+
+	ctx, span := tracer.Start(auditContext(r.ctx, "repair", AuditTriggerAlert), "repair.alert")
+	defer span.End()
+
+	plan := newPlanReport("repair", AuditTriggerAlert, r.Config.DryRun)
+	defer r.planFinish(contextLogger, plan)
+
+	repairList := []*nodeTarget{}
+	for _, alert := range alerts {
+		alertName := alert.Labels["alertname"]
+		nodeName := alert.Labels[r.Config.Repair.Alert.NodeLabel]
+
+		action, exists := r.repair.alertRules[alertName]
+		if !exists || alert.Status != "firing" || nodeName == "" {
+			continue
+		}
+
+		alertLogger := contextLogger.With(slog.String("alert", alertName), slog.String("node", nodeName))
+
+		node := r.nodeList.NodeWithAzure(nodeName)
+		if node == nil {
+			alertLogger.Warn("received alert for unknown node")
+			plan.node(nodeName).decide(PlanDecisionSkip, "", fmt.Sprintf("alert %v for unknown node", alertName))
+			continue
+		}
+
+		// node might be in multiple alerts
+		if repairListContains(repairList, nodeName) {
+			continue
+		}
+
+		alertLogger.Info("received alert for node, checking repair")
+		if r.repairNodeTrigger(ctx, alertLogger, node, &repairList, alertName, action, plan.node(nodeName)) == repairNodeResultStop {
+			break
+		}
+	}
+
+	r.repairDispatch(ctx, contextLogger, repairList)
+}
+
+// repair action of alert rule, VMs only support restart and redeploy
+func (r *AzureK8sAutopilot) repairAlertAction(contextLogger *slogger.Logger, nodeInfo *k8s.NodeInfo, action string) string {
+	if action == "" || action == repairAlertActionDefault {
+		return ""
+	}
+
+	if !repairAlertActionSupported(nodeInfo, action) {
+		contextLogger.Warn("repair action of alert rule is not supported for Azure VMs, using default action", slog.String("action", action))
+		return ""
+	}
+
+	return action
+}
+
+// checks if alert action can be used for the node (Azure VMs only support restart and redeploy), default action is not an override
+func repairAlertActionSupported(nodeInfo *k8s.NodeInfo, action string) bool {
+	if action == "" || action == repairAlertActionDefault {
+		return false
+	}
+	return nodeInfo.IsVmss || action == "restart" || action == "redeploy"
+}
+
+func repairListContains(repairList []*nodeTarget, nodeName string) bool {
+	for _, target := range repairList {
+		if target.node.Name == nodeName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package autopilot
+
+import (
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+func (r *AzureK8sAutopilot) initRepairEvents() {
+	r.repair.queue = workqueue.NewTypedRateLimitingQueueWithConfig(
+		workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "repair"},
+	)
+	r.nodeList.OnConditionChange = r.repairEnqueueNode
+}
+
+func (r *AzureK8sAutopilot) startAutopilotRepairEvents() {
+	go func() {
+		for r.repairProcessQueueItem() {
+		}
+	}()
+}
+
+// enqueue node for repair check, unhealthy nodes are checked when the NotReady threshold is reached
+func (r *AzureK8sAutopilot) repairEnqueueNode(node *k8s.Node) {
+	nodeIsHealthy, nodeLastHeartbeat := node.GetHealthStatus()
+	if nodeIsHealthy {
+		r.repair.queue.Add(node.Name)
+		return
+	}
+
+	delay := r.Config.Repair.NotReadyThreshold - time.Since(nodeLastHeartbeat)
+	r.Logger.Debug("node condition changed, scheduling repair check", slog.String("node", node.Name), slog.Duration("delay", delay))
+	r.repair.queue.AddAfter(node.Name, delay)
+}
+
+func (r *AzureK8sAutopilot) repairProcessQueueItem() bool {
+	nodeName, shutdown := r.repair.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.repair.queue.Done(nodeName)
+
+	// shutdown was started, remaining items are not processed anymore
+	if !r.workStart() {
+		return false
+	}
+	defer r.wg.Done()
+
+	node := r.nodeList.NodeWithAzure(nodeName)
+	if node == nil {
+		// node is gone
+		r.repair.queue.Forget(nodeName)
+		return true
+	}
+
+	contextLogger := r.Logger.With(slog.String("job", "repair"), slog.String("trigger", "event"))
+
+	r.repair.lock.Lock()
+	defer r.repair.lock.Unlock()
+
+	// expired locks and lock cache
+	r.repairSyncLocks(contextLogger)
+
+	ctx, span := tracer.Start(auditContext(r.ctx, "repair", AuditTriggerEvent), "repair.event", trace.WithAttributes(attributeNode.String(nodeName)))
+	defer span.End()
+
+	plan := newPlanReport("repair", AuditTriggerEvent, r.Config.DryRun)
+	repairList := []*nodeTarget{}
+	result := r.repairNode(ctx, contextLogger, node, &repairList, plan.node(node.Name))
+	r.planFinish(contextLogger, plan)
+	r.repairDispatch(ctx, contextLogger, repairList)
+
+	switch result {
+	case repairNodeResultPending:
+		// heartbeat was updated in the meantime, check again when threshold is reached
+		r.repair.queue.Forget(nodeName)
+		r.repairEnqueueNode(node)
+	case repairNodeResultDeferred:
+		r.repair.queue.AddRateLimited(nodeName)
+	default:
+		r.repair.queue.Forget(nodeName)
+	}
+
+	return true
+}
@@ -1,120 +1,602 @@
 package autopilot
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/webdevops/go-common/log/slogger"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
 
 	"github.com/webdevopos/azure-k8s-autopilot/k8s"
 )
 
-func (r *AzureK8sAutopilot) repairRun(contextLogger *slogger.Logger) {
-	r.nodeList.Cleanup()
-	nodeList := r.nodeList.NodeList()
+const (
+	// node is healthy or was processed
+	repairNodeResultDone = iota
+	// node is unhealthy but threshold is not reached yet
+	repairNodeResultPending
+	// node is unhealthy but was skipped (locked, limit reached)
+	repairNodeResultDeferred
+	// autopilot is evicting itself, stop processing
+	repairNodeResultStop
+)
 
-	repairThresholdSeconds := r.Config.Repair.NotReadyThreshold.Seconds()
+func (r *AzureK8sAutopilot) repairRun(ctx context.Context, contextLogger *slogger.Logger) {
+	if !r.Config.DryRun {
+		r.nodeList.Cleanup(ctx)
+	}
+	nodeList, err := r.nodeList.NodeListWithAzure()
+	if err != nil {
+		// Azure inventory is optional for repair, state is fetched for each repaired node
+		contextLogger.Warn("unable to fetch Azure inventory", slog.Any("error", err))
+		nodeList = r.nodeList.NodeList()
+	}
 
 	contextLogger.Debugf("found %v nodes in cluster (%v in locked state)", len(nodeList), r.repair.nodeLock.ItemCount())
 
+	plan := newPlanReport("repair", AuditTriggerCron, r.Config.DryRun)
+	defer r.planFinish(contextLogger, plan)
+
+	// collect nodes which should be repaired, VMSS instances are repaired in batches
+	repairList := []*nodeTarget{}
 	for _, node := range nodeList {
-		nodeContextLogger := contextLogger.With(slog.String("node", node.Name))
+		if r.repairNode(ctx, contextLogger, node, &repairList, plan.node(node.Name)) == repairNodeResultStop {
+			return
+		}
+	}
 
-		nodeContextLogger.Debug("checking node")
-		r.prometheus.repair.nodeStatus.WithLabelValues(node.Name).Set(0)
+	r.repairDispatch(ctx, contextLogger, repairList)
+}
 
-		// check if node is ready/healthy
-		if nodeIsHealthy, nodeLastHeartbeat := node.GetHealthStatus(); !nodeIsHealthy {
-			// node is NOT healthy
-			nodeLastHeartbeatText := nodeLastHeartbeat.String()
-			nodeLastHeartbeatAge := time.Since(nodeLastHeartbeat).Seconds()
+// checks node and adds it to repairList if repair is needed
+func (r *AzureK8sAutopilot) repairNode(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node, repairList *[]*nodeTarget, plan *PlanNode) int {
+	nodeContextLogger := contextLogger.With(slog.String("node", node.Name))
 
-			// ignore cordoned nodes, maybe maintenance work in progress
-			if node.Spec.Unschedulable {
-				nodeContextLogger.Info("detected unhealthy node, ignoring because node is cordoned")
-				continue
-			}
+	nodeContextLogger.Debug("checking node")
+	r.prometheus.repair.nodeStatus.WithLabelValues(node.Name).Set(0)
 
-			// check if heartbeat already exceeded threshold
-			if nodeLastHeartbeatAge < repairThresholdSeconds {
-				nodeContextLogger.Info("detected unhealthy node, but deadline not reached yet", slog.String("lastHeartbeat", nodeLastHeartbeatText), slog.Duration("deadline", r.Config.Repair.NotReadyThreshold))
-				continue
-			}
+	// check if node is ready/healthy
+	nodeIsHealthy, nodeLastHeartbeat := node.GetHealthStatus()
+	if nodeIsHealthy {
+		// node IS healthy
+		nodeContextLogger.Debugf("detected healthy node")
+		plan.check("health", true, "Ready")
+		plan.decide(PlanDecisionNone, "", "node is healthy")
+		r.repair.nodeLock.Delete(node.Name)
+		r.metricsNodeSkipReset("repair", node.Name)
+		if !r.Config.DryRun {
+			r.repairOutOfServiceTaintRemove(ctx, nodeContextLogger, node)
+		}
+		return repairNodeResultDone
+	}
 
-			r.prometheus.repair.nodeStatus.WithLabelValues(node.Name).Set(1)
+	// node is NOT healthy
+	nodeLastHeartbeatText := nodeLastHeartbeat.String()
+	nodeLastHeartbeatAge := time.Since(nodeLastHeartbeat).Seconds()
+	plan.check("health", false, "NotReady (last heartbeat %v)", nodeLastHeartbeat.Format(time.RFC3339))
 
-			var err error
+	// check if heartbeat already exceeded threshold
+	nodeNotReadyText := fmt.Sprintf("NotReady for %v (threshold %v)", time.Since(nodeLastHeartbeat).Round(time.Second), r.Config.Repair.NotReadyThreshold)
+	if nodeLastHeartbeatAge < r.Config.Repair.NotReadyThreshold.Seconds() {
+		nodeContextLogger.Info("detected unhealthy node, but deadline not reached yet", slog.String("lastHeartbeat", nodeLastHeartbeatText), slog.Duration("deadline", r.Config.Repair.NotReadyThreshold))
+		plan.check("notReadyThreshold", true, "%v", nodeNotReadyText)
+		plan.decide(PlanDecisionWait, "", "NotReady threshold not reached yet")
+		return repairNodeResultPending
+	}
+	plan.check("notReadyThreshold", false, "%v", nodeNotReadyText)
 
-			// redeploy timeout lock
-			if _, expiry, exists := r.repair.nodeLock.GetWithExpiration(node.Name); exists {
-				nodeContextLogger.Info("detected unhealthy node, still locked", slog.String("lastHeartbeat", nodeLastHeartbeatText), slog.Time("lockTime", expiry)) //nolint:gosimple
-				continue
-			}
+	return r.repairNodeTrigger(ctx, nodeContextLogger.With(slog.String("lastHeartbeat", nodeLastHeartbeatText)), node, repairList, "", "", plan)
+}
 
-			// concurrency repair limit
-			if r.Config.Repair.Limit > 0 && r.repair.nodeLock.ItemCount() >= r.Config.Repair.Limit {
-				nodeContextLogger.Info("detected unhealthy node, skipping due to concurrent repair limit", slog.String("lastHeartbeat", nodeLastHeartbeatText))
-				continue
-			}
+// checks locks and limits of unhealthy node and adds it to repairList, alert triggered repairs can override the action
+func (r *AzureK8sAutopilot) repairNodeTrigger(ctx context.Context, nodeContextLogger *slogger.Logger, node *k8s.Node, repairList *[]*nodeTarget, alert, action string, plan *PlanNode) int {
+	r.prometheus.repair.nodeStatus.WithLabelValues(node.Name).Set(1)
 
-			nodeContextLogger.Info("detected unhealthy node, starting repair", slog.String("lastHeartbeat", nodeLastHeartbeatText))
+	if alert != "" {
+		plan.check("alert", false, "%v (action %v)", alert, action)
+	}
 
-			// parse node informations from provider ID
-			nodeInfo, err := k8s.ExtractNodeInfo(node)
-			if err != nil {
-				contextLogger.Error(err.Error())
-				continue
-			}
+	// repair already running
+	if r.repairIsInflight(node.Name) {
+		nodeContextLogger.Info("detected unhealthy node, repair still in progress")
+		plan.check("inflight", true, "repair in progress")
+		plan.decide(PlanDecisionSkip, "", "repair still in progress")
+		return repairNodeResultDone
+	}
 
-			if r.Config.DryRun {
-				nodeContextLogger.Info("node repair skipped, dry run")
-				if err := r.repair.nodeLock.Add(node.Name, true, r.Config.Repair.LockDuration); err != nil {
-					nodeContextLogger.Error(err.Error())
-				}
-				continue
-			}
+	// Azure VM doesn't exist anymore (eg. deleted outside of K8s), also checked for cordoned nodes
+	if r.repairCheckMissingVm(ctx, nodeContextLogger, node, plan) {
+		return repairNodeResultDeferred
+	}
 
-			// increase metric counter
-			r.prometheus.repair.count.WithLabelValues().Inc()
+	// ignore cordoned nodes, maybe maintenance work in progress (also for alert triggered repairs)
+	if node.Spec.Unschedulable {
+		nodeContextLogger.Info("detected unhealthy node, ignoring because node is cordoned")
+		plan.check("cordoned", true, "node is cordoned")
+		plan.decide(PlanDecisionSkip, "", "node is cordoned, maybe maintenance work in progress")
+		return repairNodeResultDone
+	}
+	plan.check("cordoned", false, "node is schedulable")
 
-			// check if self eviction is needed
-			if r.checkSelfEviction(node) {
-				return
-			}
+	// redeploy timeout lock
+	if _, expiry, exists := r.repair.nodeLock.GetWithExpiration(node.Name); exists {
+		nodeContextLogger.Info("detected unhealthy node, still locked", slog.Time("lockTime", expiry)) //nolint:gosimple
+		r.metricsNodeSkipped("repair", node.Name, r.repairSkipAction(node, action), "locked")
+		plan.check("lock", true, "locked until %v", expiry.Format(time.RFC3339))
+		plan.decide(PlanDecisionSkip, "", "node is locked")
+		return repairNodeResultDeferred
+	}
+	plan.check("lock", false, "not locked")
 
-			if nodeInfo.IsVmss {
-				// node is VMSS instance
-				err = r.azureVmssInstanceRepair(nodeContextLogger, *nodeInfo)
-			} else {
-				// node is a VM
-				err = r.azureVmRepair(nodeContextLogger, *nodeInfo)
-			}
+	// concurrency repair limit
+	if r.Config.Repair.Limit > 0 {
+		activeCount := r.repairActiveCount() + len(*repairList)
+		if activeCount >= r.Config.Repair.Limit {
+			nodeContextLogger.Info("detected unhealthy node, skipping due to concurrent repair limit")
+			r.metricsNodeSkipped("repair", node.Name, r.repairSkipAction(node, action), "limit")
+			plan.check("limit", true, "%v of %v repairs active", activeCount, r.Config.Repair.Limit)
+			plan.decide(PlanDecisionSkip, "", "concurrent repair limit reached")
+			return repairNodeResultDeferred
+		}
+		plan.check("limit", false, "%v of %v repairs active", activeCount, r.Config.Repair.Limit)
+	}
 
-			if err != nil {
-				r.prometheus.general.errors.WithLabelValues("azure").Inc()
-				nodeContextLogger.Error("node repair failed: %s", slog.Any("error", err))
-				// lock vm for next redeploy, can take up to 15 mins
-				if err := r.repair.nodeLock.Add(node.Name, true, r.Config.Repair.LockDurationError); err != nil {
-					nodeContextLogger.Error(err.Error())
-				}
-				if k8sErr := node.AnnotationLockSet(r.Config.Repair.NodeLockAnnotation, r.Config.Repair.LockDurationError, r.Config.Autoscaler.ScaledownLockTime); k8sErr != nil {
-					nodeContextLogger.Error(k8sErr.Error())
-				}
-				continue
-			} else {
-				// lock vm for next redeploy, can take up to 15 mins
-				if err := r.repair.nodeLock.Add(node.Name, true, r.Config.Repair.LockDuration); err != nil {
-					nodeContextLogger.Error(err.Error())
-				}
-				if k8sErr := node.AnnotationLockSet(r.Config.Repair.NodeLockAnnotation, r.Config.Repair.LockDuration, r.Config.Autoscaler.ScaledownLockTime); k8sErr != nil {
-					nodeContextLogger.Error(k8sErr.Error())
+	// Azure API throttling
+	if throttled, until := r.azureIsThrottled(); throttled {
+		nodeContextLogger.Info("detected unhealthy node, skipping due to Azure API throttling", slog.Time("pausedUntil", until))
+		r.metricsNodeSkipped("repair", node.Name, r.repairSkipAction(node, action), "throttled")
+		plan.check("azureThrottling", true, "paused until %v", until.Format(time.RFC3339))
+		plan.decide(PlanDecisionSkip, "", "Azure API throttling")
+		return repairNodeResultDeferred
+	}
+
+	nodeContextLogger.Info("detected unhealthy node, starting repair")
+
+	// parse node informations from provider ID
+	nodeInfo, err := r.azureExtractNodeInfo(ctx, node)
+	if err != nil {
+		nodeContextLogger.Error(err.Error())
+		plan.decide(PlanDecisionSkip, "", fmt.Sprintf("unable to parse node information: %v", err))
+		return repairNodeResultDone
+	}
+
+	target := &nodeTarget{node: node, info: nodeInfo, alert: alert, action: r.repairAlertAction(nodeContextLogger, nodeInfo, action)}
+	target.action = r.repairAction(target)
+
+	_, lastHeartbeat := node.GetHealthStatus()
+	target.setInput("ready", false)
+	target.setInput("lastHeartbeat", lastHeartbeat)
+	target.setInput("notReadyThreshold", r.Config.Repair.NotReadyThreshold.String())
+	if alert != "" {
+		target.setInput("alert", alert)
+	}
+
+	// VMSS instance protection policy
+	if nodeInfo.IsVmss {
+		protected := !r.azurePolicyCheckProtection(nodeContextLogger, "repair", node, target.action, r.Config.Repair.AzureVmssProtection)
+		protectFromScaleIn, protectFromScaleSetActions := node.AzureProtectionPolicy()
+		plan.check("protectionPolicy", protected, "protectFromScaleIn=%v protectFromScaleSetActions=%v (handling %v)", protectFromScaleIn, protectFromScaleSetActions, r.Config.Repair.AzureVmssProtection)
+		if protected {
+			plan.decide(PlanDecisionSkip, target.action, "Azure VMSS instance protection policy")
+			return repairNodeResultDone
+		}
+	}
+
+	reason := fmt.Sprintf("node is NotReady longer than %v", r.Config.Repair.NotReadyThreshold)
+	if alert != "" {
+		reason = fmt.Sprintf("alert %v is firing", alert)
+	}
+
+	if r.Config.DryRun {
+		nodeContextLogger.Info("node repair skipped, dry run")
+		r.metricsNodeSkipped("repair", node.Name, target.action, "dry-run")
+		if r.planCheckProvisionState(ctx, target, plan) {
+			plan.decide(PlanDecisionAction, target.action, reason)
+		}
+		// planned repairs count for concurrency limit, repairList is not dispatched in dry run
+		*repairList = append(*repairList, target)
+		return repairNodeResultDone
+	}
+
+	// increase metric counter
+	r.prometheus.repair.count.WithLabelValues().Inc()
+
+	// check if self eviction is needed
+	if r.checkSelfEviction(node) {
+		plan.decide(PlanDecisionSkip, target.action, "autopilot is running on node, self evicting")
+		return repairNodeResultStop
+	}
+
+	plan.decide(PlanDecisionAction, target.action, reason)
+
+	r.lifecycleEvent("repair", LifecyclePhaseDetected, "", r.repairAction(target), target, nil)
+	*repairList = append(*repairList, target)
+
+	return repairNodeResultDone
+}
+
+// non-graceful node shutdown handling: taint node as out-of-service if it is down, pods are deleted and volumes are detached by K8s
+// nodes which were drained or repaired because of alerts are still running and are not tainted
+func (r *AzureK8sAutopilot) repairOutOfServiceTaintSet(ctx context.Context, contextLogger *slogger.Logger, target *nodeTarget) {
+	node := target.node
+	if !r.Config.Repair.OutOfServiceTaint || target.alert != "" || target.drained || node.HasTaint(k8s.OutOfServiceTaintKey) {
+		return
+	}
+
+	if !r.repairNodeIsDown(ctx, contextLogger, node) {
+		contextLogger.Info("node is not confirmed to be down, not adding out-of-service taint")
+		return
+	}
+
+	taint := corev1.Taint{
+		Key:    k8s.OutOfServiceTaintKey,
+		Value:  k8s.OutOfServiceTaintValue,
+		Effect: corev1.TaintEffectNoExecute,
+	}
+	annotations := map[string]string{
+		r.Config.Repair.OutOfServiceAnnotation: time.Now().Format(time.RFC3339),
+	}
+
+	contextLogger.Info("adding out-of-service taint to node")
+	if err := node.TaintSet(auditTargetContext(ctx, target), taint, annotations); err != nil {
+		contextLogger.Error("unable to add out-of-service taint to node", slog.Any("error", err))
+	}
+}
+
+// checks if node is down (Azure VM stopped or deallocated, or kubelet lease expired)
+func (r *AzureK8sAutopilot) repairNodeIsDown(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) bool {
+	switch strings.ToLower(node.AzurePowerState()) {
+	case "stopped", "deallocated":
+		return true
+	}
+
+	leaseAge, err := r.k8sKubeletLeaseAge(ctx, node)
+	if err != nil {
+		contextLogger.Warn("unable to check kubelet lease", slog.Any("error", err))
+		return false
+	}
+
+	return leaseAge > r.Config.Repair.Drain.KubeletLeaseMaxAge
+}
+
+// remove out-of-service taints set by autopilot when repair lock is expired and no repair is running
+func (r *AzureK8sAutopilot) repairOutOfServiceTaintCleanup(ctx context.Context, contextLogger *slogger.Logger, nodeList []*k8s.Node) {
+	if r.Config.DryRun {
+		return
+	}
+
+	for _, node := range nodeList {
+		if !node.AnnotationExists(r.Config.Repair.OutOfServiceAnnotation) || r.repairIsInflight(node.Name) {
+			continue
+		}
+
+		if lockDuration, exists := node.AnnotationLockCheck(r.Config.Repair.NodeLockAnnotation); exists && lockDuration != nil && lockDuration.Seconds() > 0 {
+			continue
+		}
+
+		r.repairOutOfServiceTaintRemove(ctx, contextLogger.With(slog.String("node", node.Name)), node)
+	}
+}
+
+// remove out-of-service taint if it was set by autopilot
+func (r *AzureK8sAutopilot) repairOutOfServiceTaintRemove(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) {
+	if !node.AnnotationExists(r.Config.Repair.OutOfServiceAnnotation) {
+		return
+	}
+
+	contextLogger.Info("removing out-of-service taint from node")
+	if err := node.TaintRemove(ctx, k8s.OutOfServiceTaintKey, r.Config.Repair.OutOfServiceAnnotation); err != nil {
+		contextLogger.Error("unable to remove out-of-service taint from node", slog.Any("error", err))
+	}
+}
+
+// number of locked and currently repairing nodes, used for concurrency limit
+func (r *AzureK8sAutopilot) repairActiveCount() int {
+	r.repair.inflightLock.Lock()
+	defer r.repair.inflightLock.Unlock()
+	return r.repair.nodeLock.ItemCount() + len(r.repair.inflight)
+}
+
+func (r *AzureK8sAutopilot) repairIsInflight(nodeName string) bool {
+	r.repair.inflightLock.Lock()
+	defer r.repair.inflightLock.Unlock()
+	_, exists := r.repair.inflight[nodeName]
+	return exists
+}
+
+// run repairs in background (one per VM or VMSS), can be cancelled by repairCancelInflight
+func (r *AzureK8sAutopilot) repairDispatch(ctx context.Context, contextLogger *slogger.Logger, repairList []*nodeTarget) {
+	if r.Config.DryRun {
+		return
+	}
+
+	// repairs outlive the check run (and its timeout), they are only cancelled on shutdown
+	ctx = context.WithoutCancel(ctx)
+
+	for _, group := range groupNodeTargets(repairList) {
+		ctx, cancel := contextWithOptionalTimeout(ctx, r.Config.Repair.Timeout)
+		stopShutdownCancel := context.AfterFunc(r.ctx, cancel)
+
+		r.repair.inflightLock.Lock()
+		for _, target := range group {
+			r.repair.inflight[target.node.Name] = cancel
+		}
+		r.prometheus.repair.inflight.WithLabelValues().Set(float64(len(r.repair.inflight)))
+		r.repair.inflightLock.Unlock()
+
+		r.wg.Add(1)
+		go func(group []*nodeTarget) {
+			defer r.wg.Done()
+			defer func() {
+				r.repair.inflightLock.Lock()
+				for _, target := range group {
+					delete(r.repair.inflight, target.node.Name)
 				}
-				nodeContextLogger.Infof("node successfully repaired")
+				r.prometheus.repair.inflight.WithLabelValues().Set(float64(len(r.repair.inflight)))
+				r.repair.inflightLock.Unlock()
+				stopShutdownCancel()
+				cancel()
+			}()
+
+			r.repairExecute(ctx, contextLogger, group)
+		}(group)
+	}
+}
+
+// cancel all running repairs
+func (r *AzureK8sAutopilot) repairCancelInflight() {
+	r.repair.inflightLock.Lock()
+	defer r.repair.inflightLock.Unlock()
+	for nodeName, cancel := range r.repair.inflight {
+		r.Logger.Info("cancelling ongoing repair", slog.String("node", nodeName))
+		cancel()
+	}
+}
+
+// repair a VM or multiple instances of one VMSS
+func (r *AzureK8sAutopilot) repairExecute(ctx context.Context, contextLogger *slogger.Logger, group []*nodeTarget) {
+	ctx, span := tracer.Start(ctx, "repair.nodes", trace.WithAttributes(nodeTargetSpanAttributes(group, r.repairAction(group[0]))...))
+	defer span.End()
+
+	// checking vm provision state
+	repairList := []*nodeTarget{}
+	for _, target := range group {
+		if err := r.nodeTargetCheckProvisionState(ctx, target); err != nil {
+			r.repairFinish(ctx, contextLogger, target, err)
+			continue
+		}
+		repairList = append(repairList, target)
+	}
+
+	if len(repairList) == 0 {
+		return
+	}
+
+	for _, target := range repairList {
+		target.started = time.Now()
+	}
+
+	// drain nodes which are still reachable
+	if r.Config.Repair.Drain.Enable {
+		for _, target := range repairList {
+			r.lifecycleEvent("repair", LifecyclePhaseDrain, LifecycleStatusStarted, r.repairAction(target), target, nil)
+			drainStart := time.Now()
+			cordoned, err := r.k8sRepairDrainNode(auditTargetContext(ctx, target), contextLogger, target.node)
+			target.drained = cordoned
+			switch {
+			case !cordoned:
+				r.metricsDrain("repair", r.repairAction(target), target, drainStart, metricsResultSkipped)
+				r.lifecycleEvent("repair", LifecyclePhaseDrain, LifecycleStatusSkipped, r.repairAction(target), target, nil)
+			case err != nil:
+				r.metricsDrain("repair", r.repairAction(target), target, drainStart, metricsResultFailure)
+				r.lifecycleEvent("repair", LifecyclePhaseDrain, LifecycleStatusFailed, r.repairAction(target), target, err)
+			default:
+				r.metricsDrain("repair", r.repairAction(target), target, drainStart, metricsResultSuccess)
+				r.lifecycleEvent("repair", LifecyclePhaseDrain, LifecycleStatusSucceeded, r.repairAction(target), target, nil)
 			}
-		} else {
-			// node IS healthy
-			nodeContextLogger.Debugf("detected healthy node")
-			r.repair.nodeLock.Delete(node.Name)
 		}
 	}
+
+	// nodes which are down and not drained
+	for _, target := range repairList {
+		r.repairOutOfServiceTaintSet(ctx, contextLogger.With(slog.String("node", target.node.Name)), target)
+	}
+
+	info := repairList[0].info
+	action := r.repairAction(repairList[0])
+	if !info.IsVmss {
+		// node is a VM
+		nodeLogger := contextLogger.With(slog.String("node", repairList[0].node.Name))
+		r.notifyNodeTarget(
+			NotificationEventRepairStarted, repairList[0], action,
+			fmt.Sprintf("trigger automatic repair of K8s node %v (action: %v)", info.NodeName, action),
+			nil,
+		)
+		azureCtx := azureCorrelationContext(ctx, repairList...)
+		r.lifecycleEvent("repair", LifecyclePhaseAzureAction, LifecycleStatusStarted, action, repairList[0], nil)
+		azureStart := time.Now()
+		err := r.azureVmRepair(azureCtx, nodeLogger, *info, action)
+		r.metricsAzureOperation("repair", action, repairList[0], azureStart, err)
+		r.lifecycleEvent("repair", LifecyclePhaseAzureAction, lifecycleStatus(err), action, repairList[0], err)
+		if err == nil {
+			err = r.repairVerify(ctx, nodeLogger, repairList[0])
+		}
+		r.repairFinish(ctx, contextLogger, repairList[0], err)
+		return
+	}
+
+	// nodes are VMSS instances
+	instanceIDs := nodeTargetInstanceIDs(repairList)
+	vmssLogger := contextLogger.With(slog.String("vmss", info.VMScaleSetName), slog.Any("nodes", nodeTargetNames(repairList)))
+	for _, target := range repairList {
+		r.notifyNodeTarget(
+			NotificationEventRepairStarted, target, action,
+			fmt.Sprintf("trigger automatic repair of K8s node %v (action: %v)", target.node.Name, action),
+			nil,
+		)
+	}
+	azureCtx := azureCorrelationContext(ctx, repairList...)
+	for _, target := range repairList {
+		r.lifecycleEvent("repair", LifecyclePhaseAzureAction, LifecycleStatusStarted, action, target, nil)
+	}
+	azureStart := time.Now()
+	err := r.azureVmssInstancesAction(azureCtx, vmssLogger, *info, instanceIDs, action)
+
+	// check outcome of each instance, deleted instances are gone
+	outcome := map[string]error{}
+	if err == nil && action != "delete" {
+		outcome = r.azureVmssInstancesOutcome(ctx, *info, instanceIDs, false)
+	}
+
+	for _, target := range repairList {
+		nodeErr := err
+		if nodeErr == nil {
+			nodeErr = outcome[target.info.VMInstanceID]
+		}
+		r.metricsAzureOperation("repair", action, target, azureStart, nodeErr)
+		r.lifecycleEvent("repair", LifecyclePhaseAzureAction, lifecycleStatus(nodeErr), action, target, nodeErr)
+
+		// deleted instances are not tested
+		if nodeErr == nil && action != "delete" {
+			nodeErr = r.repairVerify(ctx, contextLogger.With(slog.String("node", target.node.Name)), target)
+		}
+
+		r.repairFinish(ctx, contextLogger, target, nodeErr)
+	}
+}
+
+// set repair lock of node depending on repair result
+func (r *AzureK8sAutopilot) repairFinish(ctx context.Context, contextLogger *slogger.Logger, target *nodeTarget, err error) {
+	node := target.node
+	contextLogger = contextLogger.With(slog.String("node", node.Name))
+	ctx = auditTargetContext(ctx, target)
+
+	// lock cache is rebuilt from node annotations, so avoid concurrent sync
+	r.repair.lock.Lock()
+	defer r.repair.lock.Unlock()
+
+	r.metricsNodeFinished("repair", r.repairAction(target), target, err)
+
+	if err != nil && r.ctx.Err() != nil {
+		// shutdown while repair is running, Azure operation might still be running so keep the node locked
+		contextLogger.Warn("node repair interrupted by shutdown", slog.Any("error", err))
+		if k8sErr := node.AnnotationLockSet(ctx, r.Config.Repair.NodeLockAnnotation, r.Config.Repair.LockDuration, r.Config.Autoscaler.ScaledownLockTime); k8sErr != nil {
+			contextLogger.Error(k8sErr.Error())
+		}
+		r.lifecycleEvent("repair", LifecyclePhaseLocked, "", r.repairAction(target), target, err)
+		return
+	}
+
+	if err != nil {
+		errorClass := azureErrorClass(err)
+		contextLogger.Error("node repair failed", slog.String("errorClass", errorClass), slog.Any("error", err))
+		r.notifyNodeTarget(
+			NotificationEventRepairFailed, target, r.repairAction(target),
+			fmt.Sprintf("automatic repair of K8s node %v failed (%v): %v", node.Name, errorClass, err),
+			err,
+		)
+		// lock vm for next redeploy, can take up to 15 mins
+		if err := r.repair.nodeLock.Add(node.Name, true, r.Config.Repair.LockDurationError); err != nil {
+			contextLogger.Error(err.Error())
+		}
+		if k8sErr := node.AnnotationLockSet(ctx, r.Config.Repair.NodeLockAnnotation, r.Config.Repair.LockDurationError, r.Config.Autoscaler.ScaledownLockTime); k8sErr != nil {
+			contextLogger.Error(k8sErr.Error())
+		}
+		r.lifecycleEvent("repair", LifecyclePhaseLocked, "", r.repairAction(target), target, err)
+		r.repairUncordon(ctx, contextLogger, target)
+		return
+	}
+
+	// lock vm for next redeploy, can take up to 15 mins
+	if err := r.repair.nodeLock.Add(node.Name, true, r.Config.Repair.LockDuration); err != nil {
+		contextLogger.Error(err.Error())
+	}
+	if k8sErr := node.AnnotationLockSet(ctx, r.Config.Repair.NodeLockAnnotation, r.Config.Repair.LockDuration, r.Config.Autoscaler.ScaledownLockTime); k8sErr != nil {
+		contextLogger.Error(k8sErr.Error())
+	}
+	r.lifecycleEvent("repair", LifecyclePhaseLocked, "", r.repairAction(target), target, nil)
+	contextLogger.Infof("node successfully repaired")
+	r.notifyNodeTarget(
+		NotificationEventRepairSucceeded, target, r.repairAction(target),
+		fmt.Sprintf("automatic repair of K8s node %v succeeded", node.Name),
+		nil,
+	)
+
+	r.repairUncordon(ctx, contextLogger, target)
+}
+
+// uncordon node cordoned by drain (also after failed repairs), deleted VMSS instances are gone and nodes with failed smoke test stay cordoned
+func (r *AzureK8sAutopilot) repairUncordon(ctx context.Context, contextLogger *slogger.Logger, target *nodeTarget) {
+	if !target.drained || target.verificationFailed || (target.info.IsVmss && r.repairAction(target) == "delete") {
+		return
+	}
+
+	// repair context might be expired, only keep the trace and audit trigger
+	uncordonCtx := auditContextCopy(trace.ContextWithSpan(r.ctx, trace.SpanFromContext(ctx)), ctx)
+	err := r.k8sUncordonNode(auditTargetContext(uncordonCtx, target), contextLogger, target.node)
+	if err != nil {
+		contextLogger.Error("node uncordon failed", slog.Any("error", err))
+	}
+	r.lifecycleEvent("repair", LifecyclePhaseUncordon, lifecycleStatus(err), r.repairAction(target), target, err)
+}
+
+// remove expired locks (uncordon nodes drained before repair, remove out-of-service taints) and rebuild lock cache
+// (called while holding repair.lock)
+func (r *AzureK8sAutopilot) repairSyncLocks(contextLogger *slogger.Logger) {
+	ctx := auditContext(r.ctx, "repair", AuditTriggerLockExpired)
+
+	// automatic remove cordon state on nodes drained before repair
+	if r.Config.Repair.Drain.Enable {
+		r.autoUncordonExpiredNodes(ctx, contextLogger, r.nodeList.NodeList(), r.Config.Repair.NodeLockAnnotation)
+	}
+
+	// remove out-of-service taints of nodes with expired repair lock
+	r.repairOutOfServiceTaintCleanup(ctx, contextLogger, r.nodeList.NodeList())
+
+	// update node lock cache
+	r.syncNodeLockCache(ctx, contextLogger, r.nodeList.NodeList(), r.Config.Repair.NodeLockAnnotation, r.repair.nodeLock)
+}
+
+// verify repaired node using smoke test
+func (r *AzureK8sAutopilot) repairVerify(ctx context.Context, contextLogger *slogger.Logger, target *nodeTarget) error {
+	if r.smokeTestPod == nil {
+		return nil
+	}
+
+	ctx, span := tracer.Start(ctx, "repair.verification", trace.WithAttributes(attributeNode.String(target.node.Name)))
+	defer span.End()
+
+	r.lifecycleEvent("repair", LifecyclePhaseVerification, LifecycleStatusStarted, r.repairAction(target), target, nil)
+	err := r.smokeTestRun(ctx, contextLogger, target.node)
+	target.verificationFailed = err != nil
+	spanError(span, err)
+	r.lifecycleEvent("repair", LifecyclePhaseVerification, lifecycleStatus(err), r.repairAction(target), target, err)
+	return err
+}
+
+// expected repair action of skipped node (node information is not fetched from Azure for skipped nodes)
+func (r *AzureK8sAutopilot) repairSkipAction(node *k8s.Node, action string) string {
+	target := &nodeTarget{node: node}
+	if nodeInfo, err := k8s.ExtractNodeInfo(node); err == nil {
+		target.info = nodeInfo
+		if repairAlertActionSupported(nodeInfo, action) {
+			target.action = action
+		}
+	}
+	return r.repairAction(target)
+}
+
+// repair action for node target (action from alert rule or configured action)
+func (r *AzureK8sAutopilot) repairAction(target *nodeTarget) string {
+	if target.action != "" {
+		return target.action
+	}
+	if target.info != nil && target.info.IsVmss {
+		return r.Config.Repair.AzureVmssAction
+	}
+	return r.Config.Repair.AzureVmAction
 }
@@ -0,0 +1,166 @@
+package autopilot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+const (
+	// timeout of missing VM check and node removal (called while holding repair.lock)
+	repairMissingVmTimeout = 1 * time.Minute
+)
+
+// checks if Azure VM of node still exists, returns true if VM is gone and node should not be repaired
+// (called while holding repair.lock)
+func (r *AzureK8sAutopilot) repairCheckMissingVm(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node, plan *PlanNode) bool {
+	if node.HasAzureResource() {
+		r.repairMissingVmForget(ctx, contextLogger, node)
+		plan.check("azureVm", false, "exists")
+		return false
+	}
+
+	nodeInfo, err := k8s.ExtractNodeInfo(node)
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repairMissingVmTimeout)
+	defer cancel()
+
+	// not in inventory cache, confirm using Azure API as cache might be incomplete
+	if _, err := r.azureFetchProvisioningState(ctx, *nodeInfo); azureErrorClass(err) != AzureErrorClassNotFound {
+		r.repairMissingVmForget(ctx, contextLogger, node)
+		plan.check("azureVm", false, "exists")
+		return false
+	}
+
+	// first detection is persisted in node annotation (restarts, leader changes)
+	missingSince, exists := r.repair.missingSince[node.Name]
+	if !exists {
+		if val := r.repairMissingVmAnnotation(node); val != nil {
+			missingSince = *val
+			exists = true
+		} else {
+			missingSince = time.Now()
+			contextLogger.Warn("detected unhealthy node, Azure VM does not exist anymore", slog.String("providerID", nodeInfo.ProviderId))
+
+			if !r.Config.DryRun {
+				if err := node.AnnotationSet(ctx, r.Config.Repair.MissingVmAnnotation, missingSince.Format(time.RFC3339)); err != nil {
+					contextLogger.Error("unable to set missing VM annotation", slog.Any("error", err))
+				}
+			}
+		}
+		r.repair.missingSince[node.Name] = missingSince
+	}
+
+	plan.check("azureVm", true, "not found since %v", missingSince.Format(time.RFC3339))
+
+	if !exists && !r.Config.DryRun {
+		if err := r.k8sNodeEvent(ctx, node, corev1.EventTypeWarning, "AzureVmNotFound", fmt.Sprintf("Azure VM %s does not exist anymore", nodeInfo.ProviderId)); err != nil {
+			contextLogger.Error("unable to create node event", slog.Any("error", err))
+		}
+	}
+
+	if time.Since(missingSince) < r.Config.Repair.MissingVmGracePeriod {
+		contextLogger.Info("detected unhealthy node without Azure VM, grace period not reached yet", slog.Time("missingSince", missingSince), slog.Duration("gracePeriod", r.Config.Repair.MissingVmGracePeriod))
+		plan.decide(PlanDecisionWait, "delete-node", "Azure VM does not exist anymore, grace period not reached yet")
+		return true
+	}
+
+	if !r.Config.Repair.MissingVmDeleteNode {
+		contextLogger.Info("detected unhealthy node without Azure VM, node deletion is disabled", slog.Time("missingSince", missingSince))
+		plan.decide(PlanDecisionSkip, "", "Azure VM does not exist anymore, node deletion is disabled")
+		return true
+	}
+
+	plan.decide(PlanDecisionAction, "delete-node", "Azure VM does not exist anymore")
+	if r.Config.DryRun {
+		contextLogger.Info("node deletion skipped, dry run")
+		return true
+	}
+
+	r.repairRemoveNode(ctx, contextLogger, node, missingSince)
+	return true
+}
+
+// first detection of missing Azure VM from node annotation
+func (r *AzureK8sAutopilot) repairMissingVmAnnotation(node *k8s.Node) *time.Time {
+	if val, exists := node.Annotations[r.Config.Repair.MissingVmAnnotation]; exists {
+		if missingSince, err := time.Parse(time.RFC3339, val); err == nil {
+			return &missingSince
+		}
+	}
+	return nil
+}
+
+// forget missing Azure VM of node (VM exists again) and remove annotation (if set)
+func (r *AzureK8sAutopilot) repairMissingVmForget(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) {
+	delete(r.repair.missingSince, node.Name)
+
+	if r.Config.DryRun || !node.AnnotationExists(r.Config.Repair.MissingVmAnnotation) {
+		return
+	}
+
+	if err := node.AnnotationRemove(ctx, r.Config.Repair.MissingVmAnnotation); err != nil {
+		contextLogger.Error("unable to remove missing VM annotation", slog.Any("error", err))
+	}
+}
+
+// remove node object (and stuck pods) of node without Azure VM
+func (r *AzureK8sAutopilot) repairRemoveNode(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node, missingSince time.Time) {
+	contextLogger.Info("removing node without Azure VM")
+
+	target := &nodeTarget{node: node, action: "delete-node"}
+	target.setInput("azureVmExists", false)
+	target.setInput("missingSince", missingSince)
+	target.setInput("gracePeriod", r.Config.Repair.MissingVmGracePeriod.String())
+	auditCtx := auditTargetContext(auditContext(ctx, "repair", AuditTriggerMissingVm), target)
+
+	if r.Config.Repair.MissingVmForceDeletePods {
+		start := time.Now()
+		count, err := node.ForceDeletePods(ctx)
+		r.auditNode(auditCtx, AuditOperationNodeDeletePods, node, start, false, err)
+		if err != nil {
+			contextLogger.Error("unable to force delete pods of node", slog.Any("error", err))
+			return
+		}
+		contextLogger.Info("force deleted pods of node", slog.Int("pods", count))
+	}
+
+	if err := r.k8sNodeEvent(ctx, node, corev1.EventTypeNormal, "NodeRemoved", "removing node because Azure VM does not exist anymore"); err != nil {
+		contextLogger.Error("unable to create node event", slog.Any("error", err))
+	}
+
+	start := time.Now()
+	err := node.Delete(ctx)
+	r.auditNode(auditCtx, AuditOperationNodeDelete, node, start, false, err)
+	if err != nil {
+		contextLogger.Error("unable to delete node", slog.Any("error", err))
+		r.notify(NotificationEvent{
+			Event:      NotificationEventNodeRemoveFailed,
+			Message:    fmt.Sprintf("removal of K8s node %v without Azure VM failed: %v", node.Name, err),
+			Node:       node.Name,
+			Action:     "delete-node",
+			Error:      err.Error(),
+			ErrorClass: azureErrorClass(err),
+		})
+		return
+	}
+
+	delete(r.repair.missingSince, node.Name)
+	r.repair.nodeLock.Delete(node.Name)
+	r.notify(NotificationEvent{
+		Event:   NotificationEventNodeRemoved,
+		Message: fmt.Sprintf("removed K8s node %v, Azure VM does not exist anymore", node.Name),
+		Node:    node.Name,
+		Action:  "delete-node",
+	})
+	contextLogger.Info("node successfully removed")
+}
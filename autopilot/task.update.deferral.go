@@ -0,0 +1,156 @@
+package autopilot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+type (
+	// node update deferred by pod drain annotations
+	updateDeferral struct {
+		Node          string                 `json:"node"`
+		DeferredSince time.Time              `json:"deferredSince"`
+		BlockingPods  []k8s.PodDrainDeferral `json:"blockingPods"`
+	}
+)
+
+// checks if node update should be deferred because of pod drain annotations
+func (r *AzureK8sAutopilot) updateCheckDeferral(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) bool {
+	if r.Config.Update.DrainDeferralMax <= 0 {
+		return false
+	}
+
+	nodeLogger := contextLogger.With(slog.String("node", node.Name))
+
+	blockingPods, err := node.DrainDeferringPods(ctx)
+	if err != nil {
+		nodeLogger.Error("unable to check pod drain annotations", slog.Any("error", err))
+		return false
+	}
+
+	r.update.deferredLock.Lock()
+	if len(blockingPods) == 0 {
+		delete(r.update.deferred, node.Name)
+		r.update.deferredLock.Unlock()
+		r.updateDeferralAnnotationRemove(ctx, nodeLogger, node)
+		return false
+	}
+
+	// deferral start is persisted in node annotation (restarts, leader changes)
+	deferralStarted := false
+	deferral, exists := r.update.deferred[node.Name]
+	if !exists {
+		deferral = &updateDeferral{Node: node.Name, DeferredSince: time.Now()}
+		if deferredSince := r.updateDeferralAnnotation(node); deferredSince != nil {
+			deferral.DeferredSince = *deferredSince
+		} else {
+			deferralStarted = true
+		}
+		r.update.deferred[node.Name] = deferral
+	}
+	deferral.BlockingPods = blockingPods
+	deferredSince := deferral.DeferredSince
+	r.update.deferredLock.Unlock()
+
+	if deferralStarted && !r.Config.DryRun {
+		if err := node.AnnotationSet(ctx, r.Config.Update.DrainDeferralAnnotation, deferredSince.Format(time.RFC3339)); err != nil {
+			nodeLogger.Error("unable to set drain deferral annotation", slog.Any("error", err))
+		}
+	}
+
+	// notify only once when the node is deferred for the first time
+	if deferralStarted {
+		podNames := []string{}
+		for _, pod := range blockingPods {
+			podNames = append(podNames, pod.Namespace+"/"+pod.Name)
+		}
+		r.notify(NotificationEvent{
+			Event:   NotificationEventUpdateDrainBlocked,
+			Message: fmt.Sprintf("automatic update of K8s node %v deferred by pods %v", node.Name, strings.Join(podNames, ", ")),
+			Node:    node.Name,
+			Action:  r.Config.Update.AzureVmssAction,
+		})
+	}
+
+	if time.Since(deferredSince) >= r.Config.Update.DrainDeferralMax {
+		nodeLogger.Warn("maximum drain deferral reached, continuing with update", slog.Time("deferredSince", deferredSince), slog.Int("blockingPods", len(blockingPods)))
+		return false
+	}
+
+	for _, pod := range blockingPods {
+		nodeLogger.Info("node update deferred by pod", slog.String("pod", pod.Namespace+"/"+pod.Name), slog.String("reason", pod.Reason), slog.Time("until", pod.Until))
+	}
+
+	return true
+}
+
+// start of drain deferral from node annotation
+func (r *AzureK8sAutopilot) updateDeferralAnnotation(node *k8s.Node) *time.Time {
+	if val, exists := node.Annotations[r.Config.Update.DrainDeferralAnnotation]; exists {
+		if deferredSince, err := time.Parse(time.RFC3339, val); err == nil {
+			return &deferredSince
+		}
+	}
+	return nil
+}
+
+// remove drain deferral annotation from node (if set)
+func (r *AzureK8sAutopilot) updateDeferralAnnotationRemove(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node) {
+	if r.Config.DryRun || !node.AnnotationExists(r.Config.Update.DrainDeferralAnnotation) {
+		return
+	}
+
+	if err := node.AnnotationRemove(ctx, r.Config.Update.DrainDeferralAnnotation); err != nil {
+		contextLogger.Error("unable to remove drain deferral annotation", slog.Any("error", err))
+	}
+}
+
+// remove deferrals of nodes which are not update candidates anymore and update metrics
+func (r *AzureK8sAutopilot) updateCleanupDeferrals(ctx context.Context, contextLogger *slogger.Logger, nodeList []*k8s.Node, candidateList []*k8s.Node) {
+	candidates := map[string]bool{}
+	for _, node := range candidateList {
+		candidates[node.Name] = true
+	}
+
+	for _, node := range nodeList {
+		if !candidates[node.Name] {
+			r.updateDeferralAnnotationRemove(ctx, contextLogger.With(slog.String("node", node.Name)), node)
+		}
+	}
+
+	r.update.deferredLock.Lock()
+	defer r.update.deferredLock.Unlock()
+
+	r.prometheus.update.deferredNodes.Reset()
+	r.prometheus.update.blockingPods.Reset()
+	for nodeName, deferral := range r.update.deferred {
+		if !candidates[nodeName] {
+			delete(r.update.deferred, nodeName)
+			continue
+		}
+
+		r.prometheus.update.deferredNodes.WithLabelValues(nodeName).Set(float64(deferral.DeferredSince.Unix()))
+		for _, pod := range deferral.BlockingPods {
+			r.prometheus.update.blockingPods.WithLabelValues(nodeName, pod.Namespace, pod.Reason).Inc()
+		}
+	}
+}
+
+// nodes where update is currently deferred
+func (r *AzureK8sAutopilot) updateDeferrals() []updateDeferral {
+	r.update.deferredLock.Lock()
+	defer r.update.deferredLock.Unlock()
+
+	ret := []updateDeferral{}
+	for _, deferral := range r.update.deferred {
+		ret = append(ret, *deferral)
+	}
+	return ret
+}
@@ -1,93 +1,172 @@
 package autopilot
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
 	"github.com/webdevops/go-common/log/slogger"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/webdevopos/azure-k8s-autopilot/k8s"
 )
 
-func (r *AzureK8sAutopilot) updateRun(contextLogger *slogger.Logger) {
-	r.nodeList.Cleanup()
+func (r *AzureK8sAutopilot) updateRun(ctx context.Context, contextLogger *slogger.Logger) {
+	if !r.Config.DryRun {
+		r.nodeList.Cleanup(ctx)
+	}
 	nodeList, err := r.nodeList.NodeListWithAzure()
 	if err != nil {
 		contextLogger.Errorf("unable to fetch K8s Node list: %s", err.Error())
 		return
 	}
 
+	plan := newPlanReport("update", AuditTriggerCron, r.Config.DryRun)
+	defer r.planFinish(contextLogger, plan)
+
 	// find update candidates
-	candidateList := r.updateCollectCandidates(contextLogger, nodeList)
+	candidateList := r.updateCollectCandidates(contextLogger, nodeList, plan)
 	contextLogger.Infof("found %v nodes (%v upgradable)", len(nodeList), len(candidateList))
 	r.prometheus.general.candidateNodes.WithLabelValues("update").Set(float64(len(candidateList)))
 
 	// sanity checks
 	failedNodeCount := r.nodeList.NodeCountByProvisionState(string(armcompute.ExecutionStateFailed))
 	r.prometheus.general.failedNodes.WithLabelValues("provisionState").Set(float64(failedNodeCount))
+	plan.check("failedThreshold", failedNodeCount >= r.Config.Update.FailedThreshold, "%v of %v failed nodes", failedNodeCount, r.Config.Update.FailedThreshold)
 	if failedNodeCount >= r.Config.Update.FailedThreshold {
 		contextLogger.Infof("detected %v failed nodes in cluster, threshold of %v reached, update stopped", failedNodeCount, r.Config.Update.FailedThreshold)
+		for _, node := range candidateList {
+			plan.node(node.Name).decide(PlanDecisionSkip, r.Config.Update.AzureVmssAction, "failed threshold reached, updates are stopped")
+			r.metricsNodeSkipped("update", node.Name, r.Config.Update.AzureVmssAction, "failed-threshold")
+		}
+		if !r.update.circuitBreakerOpen {
+			r.notify(NotificationEvent{
+				Event:   NotificationEventUpdateCircuitBreaker,
+				Message: fmt.Sprintf("detected %v failed nodes in cluster, threshold of %v reached, automatic updates stopped", failedNodeCount, r.Config.Update.FailedThreshold),
+				Action:  r.Config.Update.AzureVmssAction,
+			})
+		}
+		r.update.circuitBreakerOpen = true
 		return
 	}
+	r.update.circuitBreakerOpen = false
 
-	if !r.Config.DryRun {
-		for _, node := range candidateList {
-			// concurrency update limit
-			if r.Config.Update.Limit > 0 && r.update.nodeLock.ItemCount() >= r.Config.Update.Limit {
-				contextLogger.Infof("reached concurrent update lock, skipping node updates")
-				break
-			}
-
-			// check if self eviction is needed
-			if r.checkSelfEviction(node) {
-				return
-			}
+	// collect nodes for this run, limited by free concurrency slots
+	updateList := []*nodeTarget{}
+	for _, node := range candidateList {
+		nodePlan := plan.node(node.Name)
 
-			// parse node information from provider ID
-			nodeInfo, err := k8s.ExtractNodeInfo(node)
-			if err != nil {
-				contextLogger.Error(err.Error())
+		// concurrency update limit
+		if r.Config.Update.Limit > 0 {
+			activeCount := r.update.nodeLock.ItemCount() + len(updateList)
+			if activeCount >= r.Config.Update.Limit {
+				contextLogger.With(slog.String("node", node.Name)).Infof("reached concurrent update lock, skipping node update")
+				r.metricsNodeSkipped("update", node.Name, r.Config.Update.AzureVmssAction, "limit")
+				nodePlan.check("limit", true, "%v of %v updates active", activeCount, r.Config.Update.Limit)
+				nodePlan.decide(PlanDecisionSkip, r.Config.Update.AzureVmssAction, "concurrent update limit reached")
 				continue
 			}
+			nodePlan.check("limit", false, "%v of %v updates active", activeCount, r.Config.Update.Limit)
+		}
+
+		// pods are deferring the drain, try next candidate
+		if r.updateCheckDeferral(ctx, contextLogger, node) {
+			r.metricsNodeSkipped("update", node.Name, r.Config.Update.AzureVmssAction, "deferred")
+			nodePlan.check("drainDeferral", true, "drain deferred by pod annotations")
+			nodePlan.decide(PlanDecisionWait, r.Config.Update.AzureVmssAction, "drain deferred by pod annotations")
+			continue
+		}
+		nodePlan.check("drainDeferral", false, "no pods deferring drain")
+
+		// check if self eviction is needed
+		if !r.Config.DryRun && r.checkSelfEviction(node) {
+			nodePlan.decide(PlanDecisionSkip, r.Config.Update.AzureVmssAction, "autopilot is running on node, self evicting")
+			return
+		}
+
+		// parse node information from provider ID
+		nodeInfo, err := r.azureExtractNodeInfo(ctx, node)
+		if err != nil {
+			contextLogger.Error(err.Error())
+			nodePlan.decide(PlanDecisionSkip, r.Config.Update.AzureVmssAction, fmt.Sprintf("unable to parse node information: %v", err))
+			continue
+		}
+
+		target := &nodeTarget{node: node, info: nodeInfo, action: r.Config.Update.AzureVmssAction}
+		target.setInput("latestModelApplied", node.AzureLatestModelApplied())
+		target.setInput("ongoingUpdate", node.AnnotationExists(r.Config.Update.NodeOngoingAnnotation))
+		target.setInput("failedNodes", failedNodeCount)
+		target.setInput("failedThreshold", r.Config.Update.FailedThreshold)
+
+		reason := "latest VMSS model is not applied"
+		if node.AnnotationExists(r.Config.Update.NodeOngoingAnnotation) {
+			reason = "continuing ongoing update"
+		}
 
-			nodeLogger := contextLogger.With(
-				slog.String("subscription", nodeInfo.Subscription),
-				slog.String("resourceGroup", nodeInfo.ResourceGroup),
-				slog.String("vmss", nodeInfo.VMScaleSetName),
-				slog.String("vmssInstance", nodeInfo.VMInstanceID),
-			)
-
-			contextLogger.Info("starting update of node")
-			if err := r.updateNode(nodeLogger, node, nodeInfo); err != nil {
-				// update failed
-				contextLogger.Error(err.Error())
-				r.updateNodeLock(contextLogger, node, r.Config.Update.LockDurationError)
-				break
-			} else {
-				// update successfull
-				// lock vm for next redeploy, can take up to 15 mins
-				r.updateNodeLock(contextLogger, node, r.Config.Update.LockDuration)
+		if r.Config.DryRun {
+			r.metricsNodeSkipped("update", node.Name, r.Config.Update.AzureVmssAction, "dry-run")
+			if r.planCheckProvisionState(ctx, target, nodePlan) {
+				nodePlan.decide(PlanDecisionAction, target.action, reason)
 			}
+			// planned updates count for concurrency limit, updateList is not processed in dry run
+			updateList = append(updateList, target)
+			continue
+		}
+
+		nodePlan.decide(PlanDecisionAction, target.action, reason)
+		r.lifecycleEvent("update", LifecyclePhaseDetected, "", r.Config.Update.AzureVmssAction, target, nil)
+		updateList = append(updateList, target)
+	}
+
+	r.updateCleanupDeferrals(ctx, contextLogger, nodeList, candidateList)
+
+	if r.Config.DryRun {
+		contextLogger.Info("node updates skipped, dry run", slog.Any("nodes", nodeTargetNames(updateList)))
+		return
+	}
+
+	// update nodes in batches per VMSS
+	for _, group := range groupNodeTargets(updateList) {
+		// stop if run was cancelled (eg. shutdown or timeout)
+		if ctx.Err() != nil {
+			contextLogger.Warn("update run cancelled", slog.Any("error", ctx.Err()))
+			break
+		}
+
+		info := group[0].info
+		vmssLogger := contextLogger.With(
+			slog.String("subscription", info.Subscription),
+			slog.String("resourceGroup", info.ResourceGroup),
+			slog.String("vmss", info.VMScaleSetName),
+			slog.Any("nodes", nodeTargetNames(group)),
+		)
+
+		vmssLogger.Info("starting update of nodes")
+		if !r.updateNodes(ctx, vmssLogger, group) {
+			// stop update run, failed nodes need investigation
+			break
 		}
 	}
 }
 
-func (r *AzureK8sAutopilot) updateCollectCandidates(contextLogger *slogger.Logger, nodeList []*k8s.Node) (candidateList []*k8s.Node) {
+func (r *AzureK8sAutopilot) updateCollectCandidates(contextLogger *slogger.Logger, nodeList []*k8s.Node, plan *PlanReport) (candidateList []*k8s.Node) {
 	candidateList = []*k8s.Node{}
 
 	// check if there are ongoing updates (eg. operator was killed while doing updates)
 	for _, v := range nodeList {
 		node := v
 
-		// check if node is excluded
-		if node.AnnotationExists(r.Config.Update.NodeExcludeAnnotation) {
+		// check if node is excluded or failed verification
+		if node.AnnotationExists(r.Config.Update.NodeExcludeAnnotation) || r.nodeVerificationFailed(node) {
 			continue
 		}
 
 		if node.AnnotationExists(r.Config.Update.NodeOngoingAnnotation) {
 			// annotation found, continue with update of this node and only this node
+			plan.check("ongoingUpdate", true, "update of node %v is continued, other nodes are not checked", node.Name)
+			plan.node(node.Name).check("ongoingUpdate", false, "annotation %v is set", r.Config.Update.NodeOngoingAnnotation)
 			candidateList = append(candidateList, node)
 			return
 		}
@@ -96,25 +175,66 @@ func (r *AzureK8sAutopilot) updateCollectCandidates(contextLogger *slogger.Logge
 	// check if there are nodes which needs updates
 	for _, v := range nodeList {
 		node := v
+		nodePlan := plan.node(node.Name)
 
 		// check if node is excluded
 		if node.AnnotationExists(r.Config.Update.NodeExcludeAnnotation) {
+			nodePlan.check("exclusion", true, "annotation %v is set", r.Config.Update.NodeExcludeAnnotation)
+			nodePlan.decide(PlanDecisionSkip, "", "node is excluded from updates")
 			continue
 		}
+		nodePlan.check("exclusion", false, "not excluded")
 
-		if node.AzureVmss != nil {
-			if node.AzureVmss.Properties.LatestModelApplied != nil && !*node.AzureVmss.Properties.LatestModelApplied {
-				contextLogger.With(slog.String("node", node.Name)).Infof("found updatable node")
-				candidateList = append(candidateList, node)
-			}
+		// node needs investigation, stays cordoned
+		if r.nodeVerificationFailed(node) {
+			nodePlan.check("verification", true, "warm-up or smoke test failed (annotation %v or %v is set)", r.Config.Update.Warmup.FailedAnnotation, r.Config.SmokeTest.FailedAnnotation)
+			nodePlan.decide(PlanDecisionSkip, "", "verification of node failed, node needs investigation")
+			continue
+		}
+
+		// VMSS (uniform and flex) instances
+		latestModelApplied := node.AzureLatestModelApplied()
+		if latestModelApplied == nil {
+			nodePlan.check("latestModelApplied", true, "unknown (no VMSS instance)")
+			nodePlan.decide(PlanDecisionNone, "", "node is not a VMSS instance")
+			continue
+		}
+
+		nodePlan.check("latestModelApplied", *latestModelApplied, "%v", *latestModelApplied)
+		if *latestModelApplied {
+			nodePlan.decide(PlanDecisionNone, "", "latest VMSS model is applied")
+			r.metricsNodeSkipReset("update", node.Name)
+			continue
+		}
+
+		// VMSS instance protection policy and scale set upgrade policy
+		protected := !r.azurePolicyCheckProtection(contextLogger, "update", node, r.Config.Update.AzureVmssAction, r.Config.Update.AzureVmssProtection)
+		protectFromScaleIn, protectFromScaleSetActions := node.AzureProtectionPolicy()
+		nodePlan.check("protectionPolicy", protected, "protectFromScaleIn=%v protectFromScaleSetActions=%v (handling %v)", protectFromScaleIn, protectFromScaleSetActions, r.Config.Update.AzureVmssProtection)
+		if protected {
+			nodePlan.decide(PlanDecisionSkip, r.Config.Update.AzureVmssAction, "Azure VMSS instance protection policy")
+			continue
 		}
+
+		upgradePolicyBlocked := !r.azurePolicyCheckUpgradePolicy(contextLogger, "update", node, r.Config.Update.AzureVmssAction, r.Config.Update.AzureVmssUpgradePolicy)
+		nodePlan.check("upgradePolicy", upgradePolicyBlocked, "%v (handling %v)", node.AzureUpgradePolicyMode(), r.Config.Update.AzureVmssUpgradePolicy)
+		if upgradePolicyBlocked {
+			nodePlan.decide(PlanDecisionSkip, r.Config.Update.AzureVmssAction, "Azure VMSS upgrade policy")
+			continue
+		}
+
+		contextLogger.With(slog.String("node", node.Name)).Infof("found updatable node")
+		candidateList = append(candidateList, node)
 	}
 	return
 }
 
-func (r *AzureK8sAutopilot) updateNode(contextLogger *slogger.Logger, node *k8s.Node, nodeInfo *k8s.NodeInfo) error {
-	// trigger Azure VMSS instance update
-	r.prometheus.update.count.WithLabelValues().Inc()
+// update instances of one VMSS, returns false if at least one node failed
+func (r *AzureK8sAutopilot) updateNodes(ctx context.Context, contextLogger *slogger.Logger, group []*nodeTarget) bool {
+	ctx, span := tracer.Start(ctx, "update.nodes", trace.WithAttributes(nodeTargetSpanAttributes(group, r.Config.Update.AzureVmssAction)...))
+	defer span.End()
+
+	success := true
 
 	annotations := map[string]string{
 		// mark node as ongoing update
@@ -123,35 +243,170 @@ func (r *AzureK8sAutopilot) updateNode(contextLogger *slogger.Logger, node *k8s.
 		k8s.ClusterAutoscaleScaleDownDisableAnnotation: "true",
 	}
 
-	if err := node.AnnotationsSet(annotations); err != nil {
-		return err
+	// prepare nodes
+	updateList := []*nodeTarget{}
+	for _, target := range group {
+		// trigger Azure VMSS instance update
+		r.prometheus.update.count.WithLabelValues().Inc()
+
+		err := target.node.AnnotationsSet(auditTargetContext(ctx, target), annotations)
+		if err == nil {
+			// checking vm provision state
+			err = r.nodeTargetCheckProvisionState(ctx, target)
+		}
+		if err != nil {
+			r.updateNodeFailed(ctx, contextLogger, target, err)
+			success = false
+			continue
+		}
+
+		updateList = append(updateList, target)
+	}
+
+	if len(updateList) == 0 {
+		return success
 	}
 
+	for _, target := range updateList {
+		target.started = time.Now()
+		r.notifyNodeTarget(
+			NotificationEventUpdateStarted, target, r.Config.Update.AzureVmssAction,
+			fmt.Sprintf("trigger automatic update of K8s node %v", target.node.Name),
+			nil,
+		)
+	}
+
+	// drain nodes
+	drainedList := []*nodeTarget{}
+	for _, target := range updateList {
+		r.lifecycleEvent("update", LifecyclePhaseDrain, LifecycleStatusStarted, r.Config.Update.AzureVmssAction, target, nil)
+		drainStart := time.Now()
+		err := r.k8sDrainNode(auditTargetContext(ctx, target), contextLogger, target.node)
+		r.metricsDrain("update", r.Config.Update.AzureVmssAction, target, drainStart, metricsResult(err))
+		if err != nil {
+			r.lifecycleEvent("update", LifecyclePhaseDrain, LifecycleStatusFailed, r.Config.Update.AzureVmssAction, target, err)
+			r.updateNodeFailed(ctx, contextLogger, target, fmt.Errorf("node %s failed to drain: %w", target.node.Name, err))
+			success = false
+			continue
+		}
+		r.lifecycleEvent("update", LifecyclePhaseDrain, LifecycleStatusSucceeded, r.Config.Update.AzureVmssAction, target, nil)
+		drainedList = append(drainedList, target)
+	}
+
+	if len(drainedList) == 0 {
+		return success
+	}
+
+	// trigger batched Azure VMSS instance update
+	info := drainedList[0].info
+	instanceIDs := nodeTargetInstanceIDs(drainedList)
 	doReimage := r.Config.Update.AzureVmssAction == "update+reimage"
-	err := r.azureVmssInstanceUpdate(contextLogger, node, *nodeInfo, doReimage)
-	if err != nil {
-		r.prometheus.general.errors.WithLabelValues("azure").Inc()
-		return fmt.Errorf("node upgrade failed: %w", err)
-	} else {
-		// uncordon node
-		if err := r.k8sUncordonNode(contextLogger, node); err != nil {
-			return fmt.Errorf("node failed to uncordon: %w", err)
+	azureCtx := azureCorrelationContext(ctx, drainedList...)
+	for _, target := range drainedList {
+		r.lifecycleEvent("update", LifecyclePhaseAzureAction, LifecycleStatusStarted, r.Config.Update.AzureVmssAction, target, nil)
+	}
+	azureStart := time.Now()
+	err := r.azureVmssInstancesUpdate(azureCtx, contextLogger, *info, instanceIDs, doReimage)
+
+	// check outcome of each instance
+	outcome := map[string]error{}
+	if err == nil {
+		outcome = r.azureVmssInstancesOutcome(ctx, *info, instanceIDs, true)
+	}
+
+	for _, target := range drainedList {
+		nodeErr := err
+		if nodeErr == nil {
+			nodeErr = outcome[target.info.VMInstanceID]
 		}
-		contextLogger.Info("node successfully updated")
+		r.metricsAzureOperation("update", r.Config.Update.AzureVmssAction, target, azureStart, nodeErr)
+		r.lifecycleEvent("update", LifecyclePhaseAzureAction, lifecycleStatus(nodeErr), r.Config.Update.AzureVmssAction, target, nodeErr)
 
-		if err := node.AnnotationRemove(r.Config.Update.NodeOngoingAnnotation); err != nil {
-			return err
+		if nodeErr != nil {
+			r.updateNodeFailed(ctx, contextLogger, target, nodeErr)
+			success = false
+			continue
 		}
+
+		if err := r.updateNodeFinish(ctx, contextLogger, target); err != nil {
+			r.updateNodeFailed(ctx, contextLogger, target, err)
+			success = false
+			continue
+		}
+
+		// update successfull
+		// lock vm for next redeploy, can take up to 15 mins
+		r.updateNodeLock(auditTargetContext(ctx, target), contextLogger, target.node, r.Config.Update.LockDuration)
+		r.lifecycleEvent("update", LifecyclePhaseLocked, "", r.Config.Update.AzureVmssAction, target, nil)
+		r.metricsNodeFinished("update", r.Config.Update.AzureVmssAction, target, nil)
+		r.notifyNodeTarget(
+			NotificationEventUpdateSucceeded, target, r.Config.Update.AzureVmssAction,
+			fmt.Sprintf("automatic update of K8s node %v succeeded", target.node.Name),
+			nil,
+		)
 	}
 
-	return nil
+	return success
+}
+
+// uncordon node after successfull update, warm-up and smoke test
+func (r *AzureK8sAutopilot) updateNodeFinish(ctx context.Context, contextLogger *slogger.Logger, target *nodeTarget) error {
+	ctx, span := tracer.Start(ctx, "update.finish", trace.WithAttributes(attributeNode.String(target.node.Name)))
+	defer span.End()
+	ctx = auditTargetContext(ctx, target)
+
+	nodeLogger := contextLogger.With(slog.String("node", target.node.Name))
+
+	// node stays cordoned if warm-up or smoke test fails
+	r.lifecycleEvent("update", LifecyclePhaseVerification, LifecycleStatusStarted, r.Config.Update.AzureVmssAction, target, nil)
+	err := r.updateNodeWarmup(ctx, nodeLogger, target.node.Name)
+	if err != nil {
+		// mark node, it is not uncordoned when the lock expires
+		if k8sErr := target.node.AnnotationSet(ctx, r.Config.Update.Warmup.FailedAnnotation, time.Now().Format(time.RFC3339)); k8sErr != nil {
+			nodeLogger.Error("unable to set warm-up failed annotation", slog.Any("error", k8sErr))
+		}
+	} else {
+		err = r.smokeTestRun(ctx, nodeLogger, target.node)
+	}
+	r.lifecycleEvent("update", LifecyclePhaseVerification, lifecycleStatus(err), r.Config.Update.AzureVmssAction, target, err)
+	if err != nil {
+		return err
+	}
+
+	// uncordon node
+	err = r.k8sUncordonNode(ctx, nodeLogger, target.node)
+	r.lifecycleEvent("update", LifecyclePhaseUncordon, lifecycleStatus(err), r.Config.Update.AzureVmssAction, target, err)
+	if err != nil {
+		return fmt.Errorf("node %s failed to uncordon: %w", target.node.Name, err)
+	}
+	nodeLogger.Info("node successfully updated")
+
+	annotations := []string{r.Config.Update.NodeOngoingAnnotation}
+	if target.node.AnnotationExists(r.Config.Update.DrainDeferralAnnotation) {
+		annotations = append(annotations, r.Config.Update.DrainDeferralAnnotation)
+	}
+	return target.node.AnnotationRemove(ctx, annotations...)
+}
+
+// report failed node update and lock node
+func (r *AzureK8sAutopilot) updateNodeFailed(ctx context.Context, contextLogger *slogger.Logger, target *nodeTarget, err error) {
+	errorClass := azureErrorClass(err)
+	contextLogger.With(slog.String("node", target.node.Name)).Error("node upgrade failed", slog.String("errorClass", errorClass), slog.Any("error", err))
+	r.notifyNodeTarget(
+		NotificationEventUpdateFailed, target, r.Config.Update.AzureVmssAction,
+		fmt.Sprintf("automatic update of K8s node %v failed (%v): %v", target.node.Name, errorClass, err),
+		err,
+	)
+	r.updateNodeLock(auditTargetContext(ctx, target), contextLogger, target.node, r.Config.Update.LockDurationError)
+	r.lifecycleEvent("update", LifecyclePhaseLocked, "", r.Config.Update.AzureVmssAction, target, err)
+	r.metricsNodeFinished("update", r.Config.Update.AzureVmssAction, target, err)
 }
 
-func (r *AzureK8sAutopilot) updateNodeLock(contextLogger *slogger.Logger, node *k8s.Node, dur time.Duration) {
+func (r *AzureK8sAutopilot) updateNodeLock(ctx context.Context, contextLogger *slogger.Logger, node *k8s.Node, dur time.Duration) {
 	if err := r.update.nodeLock.Add(node.Name, true, dur); err != nil {
 		contextLogger.Error(err.Error())
 	}
-	if k8sErr := node.AnnotationLockSet(r.Config.Update.NodeLockAnnotation, dur, r.Config.Autoscaler.ScaledownLockTime); k8sErr != nil {
+	if k8sErr := node.AnnotationLockSet(ctx, r.Config.Update.NodeLockAnnotation, dur, r.Config.Autoscaler.ScaledownLockTime); k8sErr != nil {
 		contextLogger.Error(k8sErr.Error())
 	}
 }
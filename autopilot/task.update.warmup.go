@@ -0,0 +1,123 @@
+package autopilot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/webdevopos/azure-k8s-autopilot/k8s"
+)
+
+const (
+	warmupPollInterval = 10 * time.Second
+)
+
+// wait until node is warmed up (ready, DaemonSet pods ready, node conditions true)
+func (r *AzureK8sAutopilot) updateNodeWarmup(ctx context.Context, contextLogger *slogger.Logger, nodeName string) error {
+	if r.Config.Update.Warmup.Timeout <= 0 {
+		return nil
+	}
+
+	contextLogger.Info("waiting for node warm-up", slog.Duration("timeout", r.Config.Update.Warmup.Timeout))
+
+	ctx, cancel := context.WithTimeout(ctx, r.Config.Update.Warmup.Timeout)
+	defer cancel()
+
+	for {
+		pending, err := r.updateNodeWarmupPending(ctx, nodeName)
+		if err == nil && len(pending) == 0 {
+			contextLogger.Info("node warm-up finished")
+			return nil
+		}
+
+		if err != nil {
+			contextLogger.Warn("unable to check node warm-up", slog.Any("error", err))
+		} else {
+			contextLogger.Debug("waiting for node warm-up", slog.Any("pending", pending))
+		}
+
+		select {
+		case <-time.After(warmupPollInterval):
+		case <-ctx.Done():
+			if len(pending) > 0 {
+				return fmt.Errorf("node %s warm-up not finished after %s: %s", nodeName, r.Config.Update.Warmup.Timeout.String(), strings.Join(pending, ", "))
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// list of pending warm-up checks
+func (r *AzureK8sAutopilot) updateNodeWarmupPending(ctx context.Context, nodeName string) ([]string, error) {
+	// node is updated by watch
+	node := r.nodeList.Node(nodeName)
+	if node == nil {
+		return nil, fmt.Errorf("node %s not found", nodeName)
+	}
+
+	pending := []string{}
+
+	if nodeIsHealthy, _ := node.GetHealthStatus(); !nodeIsHealthy {
+		pending = append(pending, "node not ready")
+	}
+
+	// custom node conditions
+	for _, conditionType := range r.Config.Update.Warmup.Conditions {
+		conditionTrue := false
+		for _, condition := range node.Status.Conditions {
+			if strings.EqualFold(string(condition.Type), conditionType) && condition.Status == corev1.ConditionTrue {
+				conditionTrue = true
+			}
+		}
+		if !conditionTrue {
+			pending = append(pending, fmt.Sprintf("condition %s not true", conditionType))
+		}
+	}
+
+	// DaemonSet pods
+	pods, err := node.Pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	daemonSets := map[string]bool{}
+	for _, pod := range pods {
+		daemonSet := podDaemonSet(pod)
+		if daemonSet == "" {
+			continue
+		}
+		daemonSets[daemonSet] = true
+
+		if len(r.Config.Update.Warmup.DaemonSets) > 0 && !stringArrayContains(r.Config.Update.Warmup.DaemonSets, daemonSet) {
+			continue
+		}
+
+		if !k8s.PodIsReady(pod) {
+			pending = append(pending, fmt.Sprintf("pod %s/%s not ready", pod.Namespace, pod.Name))
+		}
+	}
+
+	// required DaemonSets must have a pod on node
+	for _, daemonSet := range r.Config.Update.Warmup.DaemonSets {
+		if !daemonSets[daemonSet] {
+			pending = append(pending, fmt.Sprintf("daemonset %s has no pod on node", daemonSet))
+		}
+	}
+
+	return pending, nil
+}
+
+// namespace/name of DaemonSet owning pod, empty if pod is not owned by a DaemonSet
+func podDaemonSet(pod corev1.Pod) string {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return fmt.Sprintf("%s/%s", pod.Namespace, owner.Name)
+		}
+	}
+	return ""
+}
@@ -17,7 +17,7 @@ type (
 		}
 
 		// general settings
-		DryRun bool `long:"dry-run"           env:"DRY_RUN"   description:"Dry run (no redeploy triggered)"`
+		DryRun bool `long:"dry-run"           env:"DRY_RUN"   description:"Dry run (no changes in K8s and Azure, planned actions are reported on /plan)"`
 
 		// instance
 		Instance struct {
@@ -28,7 +28,13 @@ type (
 
 		// azure
 		Azure struct {
-			Environment *string `long:"azure.environment"            env:"AZURE_ENVIRONMENT"                description:"Azure environment name" default:"AZUREPUBLICCLOUD"`
+			Environment      *string       `long:"azure.environment"            env:"AZURE_ENVIRONMENT"                description:"Azure environment name" default:"AZUREPUBLICCLOUD"`
+			OperationTimeout time.Duration `long:"azure.operation-timeout"      env:"AZURE_OPERATION_TIMEOUT"          description:"Timeout for Azure long running operations (eg. redeploy, reimage; zero means infinite)" default:"30m"`
+			CacheTtl         time.Duration `long:"azure.cache-ttl"              env:"AZURE_CACHE_TTL"                  description:"TTL of Azure inventory cache (VMs, VMSS instances and instance views)" default:"2m"`
+			InventoryBackend string        `long:"azure.inventory-backend"      env:"AZURE_INVENTORY_BACKEND"          description:"Backend for Azure inventory (resourcegraph falls back to arm on errors)" default:"arm" choice:"arm" choice:"resourcegraph"` //nolint:staticcheck
+			RetryLimit       int           `long:"azure.retry-limit"            env:"AZURE_RETRY_LIMIT"                description:"Retries of Azure actions for transient errors (throttling, conflicts, server errors)" default:"3"`
+			RetryBackoff     time.Duration `long:"azure.retry-backoff"          env:"AZURE_RETRY_BACKOFF"              description:"Initial backoff between retries of Azure actions (doubled for each retry)" default:"30s"`
+			ThrottlePause    time.Duration `long:"azure.throttle-pause"         env:"AZURE_THROTTLE_PAUSE"             description:"Pause of all Azure actions when Azure API throttling is detected (or Retry-After if longer)" default:"5m"`
 		}
 
 		Autoscaler struct {
@@ -48,38 +54,124 @@ type (
 
 		// check settings
 		Repair struct {
-			Crontab              string        `long:"repair.crontab"                  env:"REPAIR_CRONTAB"                  description:"Crontab of check runs"                                   default:"@every 2m"`
-			NotReadyThreshold    time.Duration `long:"repair.notready-threshold"       env:"REPAIR_NOTREADY_THRESHOLD"       description:"Threshold (duration) when the automatic repair should be tried (eg. after 10 mins of NotReady state after last successfull heartbeat)"        default:"10m"`
-			Limit                int           `long:"repair.concurrency"              env:"REPAIR_CONCURRENCY"              description:"How many VMs should be redeployed concurrently"          default:"1"`
-			LockDuration         time.Duration `long:"repair.lock-duration"            env:"REPAIR_LOCK_DURATION"            description:"Duration how long should be waited for another redeploy on the same node" default:"30m"`
-			LockDurationError    time.Duration `long:"repair.lock-duration-error"      env:"REPAIR_LOCK_DURATION_ERROR"      description:"Duration how long should be waited for another redeploy  on the same node in case an error occurred" default:"5m"`
-			AzureVmssAction      string        `long:"repair.azure.vmss.action"        env:"REPAIR_AZURE_VMSS_ACTION"        description:"Defines the action which should be tried to repair the node (VMSS)" default:"redeploy" choice:"restart"  choice:"redeploy" choice:"reimage" choice:"delete"`                             //nolint:staticcheck
-			AzureVmAction        string        `long:"repair.azure.vm.action"          env:"REPAIR_AZURE_VM_ACTION"          description:"Defines the action which should be tried to repair the node (VM)"   default:"redeploy" choice:"restart"  choice:"redeploy"`                                                              //nolint:staticcheck
-			ProvisioningState    []string      `long:"repair.azure.provisioningstate"  env:"REPAIR_AZURE_PROVISIONINGSTATE"  description:"Azure VM provisioning states where repair should be tried (eg. avoid repair in \"upgrading\" state; \"*\" to accept all states)"     default:"succeeded" default:"failed" env-delim:" "` //nolint:staticcheck
-			ProvisioningStateAll bool
-			NodeLockAnnotation   string `long:"repair.lock-annotation"           env:"REPAIR_LOCK_ANNOTATION"         description:"Node annotation for repair lock time"                                                                      default:"autopilot.webdevops.io/repair-lock"`
+			Crontab                  string        `long:"repair.crontab"                  env:"REPAIR_CRONTAB"                  description:"Crontab of check runs"                                   default:"@every 2m"`
+			EventDriven              bool          `long:"repair.event-driven"             env:"REPAIR_EVENT_DRIVEN"             description:"Enable event driven repair (node condition changes are checked when NotReady threshold is reached, crontab is used as safety net)"`
+			NotReadyThreshold        time.Duration `long:"repair.notready-threshold"       env:"REPAIR_NOTREADY_THRESHOLD"       description:"Threshold (duration) when the automatic repair should be tried (eg. after 10 mins of NotReady state after last successfull heartbeat)"        default:"10m"`
+			Limit                    int           `long:"repair.concurrency"              env:"REPAIR_CONCURRENCY"              description:"How many VMs should be redeployed concurrently"          default:"1"`
+			LockDuration             time.Duration `long:"repair.lock-duration"            env:"REPAIR_LOCK_DURATION"            description:"Duration how long should be waited for another redeploy on the same node" default:"30m"`
+			LockDurationError        time.Duration `long:"repair.lock-duration-error"      env:"REPAIR_LOCK_DURATION_ERROR"      description:"Duration how long should be waited for another redeploy  on the same node in case an error occurred" default:"5m"`
+			AzureVmssAction          string        `long:"repair.azure.vmss.action"        env:"REPAIR_AZURE_VMSS_ACTION"        description:"Defines the action which should be tried to repair the node (VMSS)" default:"redeploy" choice:"restart"  choice:"redeploy" choice:"reimage" choice:"delete"`                             //nolint:staticcheck
+			AzureVmAction            string        `long:"repair.azure.vm.action"          env:"REPAIR_AZURE_VM_ACTION"          description:"Defines the action which should be tried to repair the node (VM)"   default:"redeploy" choice:"restart"  choice:"redeploy"`                                                              //nolint:staticcheck
+			ProvisioningState        []string      `long:"repair.azure.provisioningstate"  env:"REPAIR_AZURE_PROVISIONINGSTATE"  description:"Azure VM provisioning states where repair should be tried (eg. avoid repair in \"upgrading\" state; \"*\" to accept all states)"     default:"succeeded" default:"failed" env-delim:" "` //nolint:staticcheck
+			ProvisioningStateAll     bool
+			NodeLockAnnotation       string        `long:"repair.lock-annotation"           env:"REPAIR_LOCK_ANNOTATION"         description:"Node annotation for repair lock time"                                                                      default:"autopilot.webdevops.io/repair-lock"`
+			Timeout                  time.Duration `long:"repair.timeout"                  env:"REPAIR_TIMEOUT"                  description:"Timeout for repair of a node (zero means infinite)"       default:"60m"`
+			RunTimeout               time.Duration `long:"repair.run-timeout"              env:"REPAIR_RUN_TIMEOUT"              description:"Timeout for a repair check run (repairs are running in background with repair.timeout; zero means infinite)" default:"15m"`
+			OutOfServiceTaint        bool          `long:"repair.out-of-service-taint"       env:"REPAIR_OUT_OF_SERVICE_TAINT"       description:"Taint unhealthy nodes which are down (Azure VM stopped or kubelet lease older than repair.drain.kubelet-lease-max-age) and not drained with node.kubernetes.io/out-of-service before the Azure action (forces pod deletion and volume detach), removed when node is ready again or repair lock expired"`
+			OutOfServiceAnnotation   string        `long:"repair.out-of-service-annotation"  env:"REPAIR_OUT_OF_SERVICE_ANNOTATION"  description:"Node annotation for out-of-service taint set by autopilot" default:"autopilot.webdevops.io/out-of-service"`
+			MissingVmGracePeriod     time.Duration `long:"repair.missing-vm.grace-period"    env:"REPAIR_MISSING_VM_GRACE_PERIOD"    description:"Duration how long the Azure VM of an unhealthy node must be missing before the node is removed" default:"15m"`
+			MissingVmAnnotation      string        `long:"repair.missing-vm.annotation"      env:"REPAIR_MISSING_VM_ANNOTATION"      description:"Node annotation for first detection of missing Azure VM (keeps grace period across restarts)" default:"autopilot.webdevops.io/vm-missing-since"`
+			MissingVmDeleteNode      bool          `long:"repair.missing-vm.delete-node"     env:"REPAIR_MISSING_VM_DELETE_NODE"     description:"Delete K8s node object if the Azure VM does not exist anymore"`
+			MissingVmForceDeletePods bool          `long:"repair.missing-vm.force-delete-pods" env:"REPAIR_MISSING_VM_FORCE_DELETE_PODS" description:"Force delete pods of node before the node object is deleted"`
+			AzureVmssProtection      string        `long:"repair.azure.vmss.protection"    env:"REPAIR_AZURE_VMSS_PROTECTION"    description:"Handling of VMSS instances with protection policy (protectFromScaleSetActions, protectFromScaleIn for delete action)" default:"skip" choice:"skip" choice:"warn" choice:"ignore"` //nolint:staticcheck
+
+			// drain before repair
+			Drain OptsRepairDrain
+
+			// repair triggered by Prometheus alerts
+			Alert OptsRepairAlert
 		}
 
 		// upgrade settings
 		Update struct {
-			Crontab               string        `long:"update.crontab"                  env:"UPDATE_CRONTAB"                  description:"Crontab of check runs"                                 default:"@every 15m"`
-			Limit                 int           `long:"update.concurrency"              env:"UPDATE_CONCURRENCY"              description:"How many VMs should be updated concurrently"           default:"1"`
-			LockDuration          time.Duration `long:"update.lock-duration"            env:"UPDATE_LOCK_DURATION"            description:"Duration how long should be waited for another update on the same node" default:"15m"`
-			LockDurationError     time.Duration `long:"update.lock-duration-error"      env:"UPDATE_LOCK_DURATION_ERROR"      description:"Duration how long should be waited for another update  on the same node in case an error occurred" default:"5m"`
-			NodeLockAnnotation    string        `long:"update.lock-annotation"          env:"UPDATE_LOCK_ANNOTATION"          description:"Node annotation for update lock time"                                                                      default:"autopilot.webdevops.io/update-lock"`
-			NodeOngoingAnnotation string        `long:"update.ongoing-annotation"       env:"UPDATE_ONGOING_ANNOTATION"       description:"Node annotation for ongoing update lock"                                                                   default:"autopilot.webdevops.io/update-ongoing"`
-			NodeExcludeAnnotation string        `long:"update.exclude-annotation"       env:"UPDATE_EXCLUDE_ANNOTATION"       description:"Node annotation for excluding node for updates"                                                            default:"autopilot.webdevops.io/exclude"`
-			AzureVmssAction       string        `long:"update.azure.vmss.action"        env:"UPDATE_AZURE_VMSS_ACTION"        description:"Defines the action which should be tried to update the node (VMSS)" default:"update+reimage" choice:"update" choice:"update+reimage" choice:"delete"`                                    //nolint:staticcheck
-			ProvisioningState     []string      `long:"update.azure.provisioningstate"  env:"UPDATE_AZURE_PROVISIONINGSTATE"  description:"Azure VM provisioning states where update should be tried (eg. avoid repair in \"upgrading\" state; \"*\" to accept all states)"     default:"succeeded" default:"failed" env-delim:" "` //nolint:staticcheck
-			ProvisioningStateAll  bool
-			FailedThreshold       int `long:"update.failed-threshold"         env:"UPDATE_FAILED_THRESHOLD"         description:"Failed node threshold when node update is stopped"           default:"2"`
+			Crontab                 string        `long:"update.crontab"                  env:"UPDATE_CRONTAB"                  description:"Crontab of check runs"                                 default:"@every 15m"`
+			Limit                   int           `long:"update.concurrency"              env:"UPDATE_CONCURRENCY"              description:"How many VMs should be updated concurrently"           default:"1"`
+			LockDuration            time.Duration `long:"update.lock-duration"            env:"UPDATE_LOCK_DURATION"            description:"Duration how long should be waited for another update on the same node" default:"15m"`
+			LockDurationError       time.Duration `long:"update.lock-duration-error"      env:"UPDATE_LOCK_DURATION_ERROR"      description:"Duration how long should be waited for another update  on the same node in case an error occurred" default:"5m"`
+			NodeLockAnnotation      string        `long:"update.lock-annotation"          env:"UPDATE_LOCK_ANNOTATION"          description:"Node annotation for update lock time"                                                                      default:"autopilot.webdevops.io/update-lock"`
+			NodeOngoingAnnotation   string        `long:"update.ongoing-annotation"       env:"UPDATE_ONGOING_ANNOTATION"       description:"Node annotation for ongoing update lock"                                                                   default:"autopilot.webdevops.io/update-ongoing"`
+			NodeExcludeAnnotation   string        `long:"update.exclude-annotation"       env:"UPDATE_EXCLUDE_ANNOTATION"       description:"Node annotation for excluding node for updates"                                                            default:"autopilot.webdevops.io/exclude"`
+			AzureVmssAction         string        `long:"update.azure.vmss.action"        env:"UPDATE_AZURE_VMSS_ACTION"        description:"Defines the action which should be tried to update the node (VMSS)" default:"update+reimage" choice:"update" choice:"update+reimage" choice:"delete"`                                    //nolint:staticcheck
+			ProvisioningState       []string      `long:"update.azure.provisioningstate"  env:"UPDATE_AZURE_PROVISIONINGSTATE"  description:"Azure VM provisioning states where update should be tried (eg. avoid repair in \"upgrading\" state; \"*\" to accept all states)"     default:"succeeded" default:"failed" env-delim:" "` //nolint:staticcheck
+			ProvisioningStateAll    bool
+			FailedThreshold         int           `long:"update.failed-threshold"         env:"UPDATE_FAILED_THRESHOLD"         description:"Failed node threshold when node update is stopped"           default:"2"`
+			Timeout                 time.Duration `long:"update.timeout"                  env:"UPDATE_TIMEOUT"                  description:"Timeout for an update run (zero means infinite)"            default:"120m"`
+			AzureVmssProtection     string        `long:"update.azure.vmss.protection"    env:"UPDATE_AZURE_VMSS_PROTECTION"    description:"Handling of VMSS instances with protection policy (protectFromScaleSetActions, protectFromScaleIn for delete action)" default:"skip" choice:"skip" choice:"warn" choice:"ignore"` //nolint:staticcheck
+			DrainDeferralMax        time.Duration `long:"update.drain-deferral-max" env:"UPDATE_DRAIN_DEFERRAL_MAX" description:"Maximum duration a node update can be deferred by pod drain annotations (zero disables pod drain deferral)" default:"0"`
+			DrainDeferralAnnotation string        `long:"update.drain-deferral-annotation" env:"UPDATE_DRAIN_DEFERRAL_ANNOTATION" description:"Node annotation for start of drain deferral (keeps maximum deferral across restarts)" default:"autopilot.webdevops.io/drain-deferred-since"`
+			AzureVmssUpgradePolicy  string        `long:"update.azure.vmss.upgrade-policy" env:"UPDATE_AZURE_VMSS_UPGRADE_POLICY" description:"Handling of scale sets with upgrade policy Automatic or Rolling (skip: only update scale sets with Manual upgrade policy)" default:"skip" choice:"skip" choice:"warn" choice:"ignore"` //nolint:staticcheck
+
+			// warm-up checks before uncordon
+			Warmup struct {
+				Timeout          time.Duration `long:"update.warmup.timeout"     env:"UPDATE_WARMUP_TIMEOUT"     description:"Timeout for node warm-up (node ready, DaemonSet pods ready, node conditions) before uncordon after update (zero disables warm-up checks)" default:"0"`
+				DaemonSets       []string      `long:"update.warmup.daemonset"   env:"UPDATE_WARMUP_DAEMONSET"   description:"DaemonSets (namespace/name) which must be ready on node before uncordon (empty means all DaemonSet pods on node)" env-delim:" "`
+				Conditions       []string      `long:"update.warmup.condition"   env:"UPDATE_WARMUP_CONDITION"   description:"Node conditions which must be true before uncordon (eg. custom node-problem-detector conditions)" env-delim:" "`
+				FailedAnnotation string        `long:"update.warmup.failed-annotation" env:"UPDATE_WARMUP_FAILED_ANNOTATION" description:"Node annotation for failed warm-up, node stays cordoned and is not updated again until the annotation is removed" default:"autopilot.webdevops.io/warmup-failed"`
+			}
+		}
+
+		// smoke test settings
+		SmokeTest struct {
+			PodTemplate      string        `long:"smoketest.pod-template"    env:"SMOKETEST_POD_TEMPLATE"    description:"Path to pod manifest (yaml) which is run on the node after update and repair before uncordon (empty disables smoke test)"`
+			Namespace        string        `long:"smoketest.namespace"       env:"SMOKETEST_NAMESPACE"       description:"Namespace for smoke test pods" default:"kube-system"`
+			Timeout          time.Duration `long:"smoketest.timeout"         env:"SMOKETEST_TIMEOUT"         description:"Timeout for smoke test pod to complete" default:"5m"`
+			FailureAction    string        `long:"smoketest.failure-action"  env:"SMOKETEST_FAILURE_ACTION"  description:"Handling of failed smoke tests (node stays cordoned; retry: retry smoke test before failing; escalate: additional warning event and escalation notification)" default:"keep-cordoned" choice:"keep-cordoned" choice:"retry" choice:"escalate"` //nolint:staticcheck
+			Retries          int           `long:"smoketest.retries"         env:"SMOKETEST_RETRIES"         description:"Retries of failed smoke tests (failure action retry)" default:"2"`
+			FailedAnnotation string        `long:"smoketest.failed-annotation" env:"SMOKETEST_FAILED_ANNOTATION" description:"Node annotation for failed smoke test, node stays cordoned and is not updated again until the annotation is removed" default:"autopilot.webdevops.io/smoketest-failed"`
+		}
+
+		// orphaned VMSS instance settings
+		Orphan struct {
+			Crontab             string        `long:"orphan.crontab"               env:"ORPHAN_CRONTAB"               description:"Crontab of checks for VMSS instances which are not registered as K8s node (empty to disable)" default:""`
+			GracePeriod         time.Duration `long:"orphan.grace-period"          env:"ORPHAN_GRACE_PERIOD"          description:"Duration how long a VMSS instance can exist without K8s node before action is triggered (counted from instance creation or last provisioning)" default:"30m"`
+			Limit               int           `long:"orphan.concurrency"           env:"ORPHAN_CONCURRENCY"           description:"How many orphaned VMSS instances should be handled per run" default:"1"`
+			LockDuration        time.Duration `long:"orphan.lock-duration"         env:"ORPHAN_LOCK_DURATION"         description:"Duration how long should be waited for another action on the same VMSS instance" default:"60m"`
+			AzureVmssAction     string        `long:"orphan.azure.vmss.action"     env:"ORPHAN_AZURE_VMSS_ACTION"     description:"Defines the action for VMSS instances which are not registered as K8s node" default:"reimage" choice:"reimage" choice:"delete"`                                                   //nolint:staticcheck
+			AzureVmssProtection string        `long:"orphan.azure.vmss.protection" env:"ORPHAN_AZURE_VMSS_PROTECTION" description:"Handling of VMSS instances with protection policy (protectFromScaleSetActions, protectFromScaleIn for delete action)" default:"skip" choice:"skip" choice:"warn" choice:"ignore"` //nolint:staticcheck
+		}
+
+		// shutdown settings
+		Shutdown struct {
+			GracePeriod time.Duration `long:"shutdown.grace-period"   env:"SHUTDOWN_GRACE_PERIOD"   description:"Time to wait for ongoing actions to finish on shutdown before they are cancelled (should be at least 5s lower than terminationGracePeriodSeconds)" default:"25s"`
 		}
 
 		// drain settings
 		Drain OptsDrain
 
 		// notification
-		Notification []string `long:"notification" env:"NOTIFICATION" description:"Shoutrrr url for notifications (https://containrrr.github.io/shoutrrr/)" env-delim:" " json:"-"`
+		Notification struct {
+			Urls             []string      `long:"notification"                     env:"NOTIFICATION"                     description:"Shoutrrr url for notifications (https://containrrr.github.io/shoutrrr/), receives all events" env-delim:" " json:"-"`
+			Config           string        `long:"notification.config"              env:"NOTIFICATION_CONFIG"              description:"Path to notification config (yaml) with templates and routing rules per event"`
+			DedupWindow      time.Duration `long:"notification.dedup-window"        env:"NOTIFICATION_DEDUP_WINDOW"        description:"Identical events (event, node, pool, action, error class) are only sent once within this window (zero disables deduplication)" default:"0"`
+			StateChangesOnly bool          `long:"notification.state-changes-only"  env:"NOTIFICATION_STATE_CHANGES_ONLY"  description:"Only notify when the outcome of a node changes (eg. repeated repair failures of a flapping node are sent once until the node is repaired successfully)"`
+			DigestInterval   time.Duration `long:"notification.digest.interval"     env:"NOTIFICATION_DIGEST_INTERVAL"     description:"Interval of digest notifications summarizing batched events (zero disables digest)" default:"0"`
+			DigestEvents     []string      `long:"notification.digest.event"        env:"NOTIFICATION_DIGEST_EVENT"        description:"Events (glob patterns) which are batched into the digest instead of being sent immediately" env-delim:" " default:"*.started" default:"*.succeeded"`
+		}
+
+		// webhook (CloudEvents) settings
+		Webhook struct {
+			Url       string        `long:"webhook.url"         env:"WEBHOOK_URL"         description:"Url where node lifecycle events are posted as CloudEvents (empty to disable)"`
+			Secret    string        `long:"webhook.secret"      env:"WEBHOOK_SECRET"      description:"Secret for HMAC-SHA256 signature of webhook payloads (header X-Autopilot-Signature)" json:"-"`
+			Source    string        `long:"webhook.source"      env:"WEBHOOK_SOURCE"      description:"CloudEvents source attribute" default:"azure-k8s-autopilot"`
+			QueueSize int           `long:"webhook.queue-size"  env:"WEBHOOK_QUEUE_SIZE"  description:"Size of in-memory queue for webhook events (events are dropped if queue is full)" default:"1000"`
+			Retries   int           `long:"webhook.retries"     env:"WEBHOOK_RETRIES"     description:"Retries of failed webhook requests (exponential backoff)" default:"5"`
+			Timeout   time.Duration `long:"webhook.timeout"     env:"WEBHOOK_TIMEOUT"     description:"Timeout of webhook requests" default:"10s"`
+		}
+
+		// OpenTelemetry tracing settings
+		Tracing struct {
+			Endpoint    string  `long:"tracing.otlp.endpoint"  env:"TRACING_OTLP_ENDPOINT"  description:"OTLP/HTTP endpoint (host:port) for OpenTelemetry traces (empty disables tracing)"`
+			Insecure    bool    `long:"tracing.otlp.insecure"  env:"TRACING_OTLP_INSECURE"  description:"Use HTTP instead of HTTPS for OTLP endpoint"`
+			ServiceName string  `long:"tracing.service-name"   env:"TRACING_SERVICE_NAME"   description:"Service name of traces" default:"azure-k8s-autopilot"`
+			SampleRatio float64 `long:"tracing.sample-ratio"   env:"TRACING_SAMPLE_RATIO"   description:"Ratio of sampled traces (0-1)" default:"1"`
+		}
+
+		// audit log settings
+		Audit struct {
+			Log           string `long:"audit.log"             env:"AUDIT_LOG"             description:"Append audit log of mutating actions as JSON lines to file (\"-\" for stdout, empty to disable)"`
+			ConfigMap     string `long:"audit.configmap"       env:"AUDIT_CONFIGMAP"       description:"Name of ConfigMap (in namespace of autopilot) keeping the latest audit log entries (empty to disable)"`
+			ConfigMapSize int    `long:"audit.configmap.size"  env:"AUDIT_CONFIGMAP_SIZE"  description:"Number of audit log entries kept in ConfigMap" default:"200"`
+		}
 
 		// server settings
 		Server struct {
@@ -90,18 +182,37 @@ type (
 		}
 	}
 
+	OptsRepairDrain struct {
+		Enable             bool          `long:"repair.drain.enable"                 env:"REPAIR_DRAIN_ENABLE"                 description:"Enable drain before repair (only if kubelet is still reachable, repair continues if drain fails)"`
+		Timeout            time.Duration `long:"repair.drain.timeout"                env:"REPAIR_DRAIN_TIMEOUT"                description:"The length of time to wait before giving up drain and continuing with repair" default:"5m"`
+		KubeletLeaseMaxAge time.Duration `long:"repair.drain.kubelet-lease-max-age"  env:"REPAIR_DRAIN_KUBELET_LEASE_MAX_AGE"  description:"Drain is only tried if kubelet renewed its node lease within this duration (older leases mark node as down for out-of-service taint)" default:"2m"`
+		DeleteEmptydirData bool          `long:"repair.drain.delete-emptydir-data"   env:"REPAIR_DRAIN_DELETE_EMPTYDIR_DATA"   description:"Continue even if there are pods using emptyDir (local emptydir that will be deleted when the node is drained)"`
+		Force              bool          `long:"repair.drain.force"                  env:"REPAIR_DRAIN_FORCE"                  description:"Continue even if there are pods not managed by a ReplicationController, ReplicaSet, Job, DaemonSet or StatefulSet"`
+		GracePeriod        int64         `long:"repair.drain.grace-period"           env:"REPAIR_DRAIN_GRACE_PERIOD"           description:"Period of time in seconds given to each pod to terminate gracefully. If negative, the default value specified in the pod will be used."`
+		IgnoreDaemonsets   bool          `long:"repair.drain.ignore-daemonsets"      env:"REPAIR_DRAIN_IGNORE_DAEMONSETS"      description:"Ignore DaemonSet-managed pods."`
+		PodSelector        string        `long:"repair.drain.pod-selector"           env:"REPAIR_DRAIN_POD_SELECTOR"           description:"Label selector to filter pods on the node"`
+	}
+
+	OptsRepairAlert struct {
+		Rules     []string `long:"repair.alert.rule"        env:"REPAIR_ALERT_RULE"        description:"Alertmanager alerts which trigger repair of the node from the alert node label (format: alertname=action; action: restart, redeploy, reimage, delete (VMSS only) or default)" env-delim:" "`
+		NodeLabel string   `long:"repair.alert.node-label"  env:"REPAIR_ALERT_NODE_LABEL"  description:"Alert label containing the K8s node name" default:"node"`
+		Token     string   `long:"repair.alert.token"       env:"REPAIR_ALERT_TOKEN"       description:"Bearer token required for Alertmanager webhook requests (webhook is disabled if empty)" json:"-"`
+	}
+
 	OptsDrain struct {
-		KubectlPath        string        `long:"drain.kubectl"               env:"DRAIN_KUBECTL"               description:"Path to kubectl binary" default:"kubectl"`
-		Enable             bool          `long:"drain.enable"                env:"DRAIN_ENABLE"                description:"Enable drain handling"`
-		DeleteEmptydirData bool          `long:"drain.delete-emptydir-data"  env:"DRAIN_DELETE_EMPTYDIR_DATA"  description:"Continue even if there are pods using emptyDir (local emptydir that will be deleted when the node is drained)"`
-		Force              bool          `long:"drain.force"                 env:"DRAIN_FORCE"                 description:"Continue even if there are pods not managed by a ReplicationController, ReplicaSet, Job, DaemonSet or StatefulSet"`
-		GracePeriod        int64         `long:"drain.grace-period"          env:"DRAIN_GRACE_PERIOD"          description:"Period of time in seconds given to each pod to terminate gracefully. If negative, the default value specified in the pod will be used."`
-		IgnoreDaemonsets   bool          `long:"drain.ignore-daemonsets"     env:"DRAIN_IGNORE_DAEMONSETS"     description:"Ignore DaemonSet-managed pods."`
-		PodSelector        string        `long:"drain.pod-selector"          env:"DRAIN_POD_SELECTOR"          description:"Label selector to filter pods on the node"`
-		Timeout            time.Duration `long:"drain.timeout"               env:"DRAIN_TIMEOUT"               description:"The length of time to wait before giving up, zero means infinite" default:"0s"`
-		WaitAfter          time.Duration `long:"drain.wait-after"            env:"DRAIN_WAIT_AFTER"            description:"Wait after drain to let Kubernetes detach volumes etc"   default:"30s"`
-		DryRun             bool          `long:"drain.dry-run"               env:"DRAIN_DRY_RUN"               description:"Do not drain, uncordon or label any node"`
-		DisableEviction    bool          `long:"drain.disable-eviction"      env:"DRAIN_DISABLE_EVICTION"      description:"Force drain to use delete, even if eviction is supported. This will bypass checking PodDisruptionBudgets, use with caution."`
+		KubectlPath         string        `long:"drain.kubectl"               env:"DRAIN_KUBECTL"               description:"Path to kubectl binary" default:"kubectl"`
+		Enable              bool          `long:"drain.enable"                env:"DRAIN_ENABLE"                description:"Enable drain handling"`
+		DeleteEmptydirData  bool          `long:"drain.delete-emptydir-data"  env:"DRAIN_DELETE_EMPTYDIR_DATA"  description:"Continue even if there are pods using emptyDir (local emptydir that will be deleted when the node is drained)"`
+		Force               bool          `long:"drain.force"                 env:"DRAIN_FORCE"                 description:"Continue even if there are pods not managed by a ReplicationController, ReplicaSet, Job, DaemonSet or StatefulSet"`
+		GracePeriod         int64         `long:"drain.grace-period"          env:"DRAIN_GRACE_PERIOD"          description:"Period of time in seconds given to each pod to terminate gracefully. If negative, the default value specified in the pod will be used."`
+		IgnoreDaemonsets    bool          `long:"drain.ignore-daemonsets"     env:"DRAIN_IGNORE_DAEMONSETS"     description:"Ignore DaemonSet-managed pods."`
+		PodSelector         string        `long:"drain.pod-selector"          env:"DRAIN_POD_SELECTOR"          description:"Label selector to filter pods on the node"`
+		Timeout             time.Duration `long:"drain.timeout"               env:"DRAIN_TIMEOUT"               description:"The length of time to wait before giving up, zero means infinite" default:"0s"`
+		WaitAfter           time.Duration `long:"drain.wait-after"            env:"DRAIN_WAIT_AFTER"            description:"Wait after drain to let Kubernetes detach volumes etc (only used if volume detach check is disabled)"   default:"30s"`
+		VolumeDetachTimeout time.Duration `long:"drain.volume-detach-timeout" env:"DRAIN_VOLUME_DETACH_TIMEOUT" description:"Timeout for waiting until all VolumeAttachments of the node are removed after drain (zero disables the check, drain.wait-after is used instead; not checked in drain.dry-run)" default:"0"`
+		VolumeDetachAzure   bool          `long:"drain.volume-detach-azure"   env:"DRAIN_VOLUME_DETACH_AZURE"   description:"Also wait until the data disk list of the Azure VM is empty (only use if no data disks are defined in VM/VMSS model)"`
+		DryRun              bool          `long:"drain.dry-run"               env:"DRAIN_DRY_RUN"               description:"Do not drain, uncordon or label any node"`
+		DisableEviction     bool          `long:"drain.disable-eviction"      env:"DRAIN_DISABLE_EVICTION"      description:"Force drain to use delete, even if eviction is supported. This will bypass checking PodDisruptionBudgets, use with caution."`
 
 		RetryWithoutEviction bool `long:"drain.retry-without-eviction"      env:"DRAIN_RETRY_WITHOUT_EVICTION"           description:"Retry drain without eviction if first drain failed"`
 		IgnoreFailure        bool `long:"drain.ignore-failure"      env:"DRAIN_IGNORE_FAILURE"           description:"Ignore failed drain and continue with actions"`
@@ -1,6 +1,7 @@
 package k8s
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os/exec"
@@ -27,7 +28,7 @@ func (k *Kubectl) SetLogger(logger *slogger.Logger) {
 	k.logger = logger
 }
 
-func (k *Kubectl) NodeDrain() error {
+func (k *Kubectl) NodeDrain(ctx context.Context) error {
 	k.logger.Info("drain node", slog.String("node", k.nodeName))
 	kubectlDrainOpts := []string{"drain", k.nodeName}
 	kubectlDrainOpts = append(kubectlDrainOpts, fmt.Sprintf("--timeout=%v", k.Conf.Timeout.String()))
@@ -56,20 +57,25 @@ func (k *Kubectl) NodeDrain() error {
 		kubectlDrainOpts = append(kubectlDrainOpts, "--disable-eviction=true")
 	}
 
-	return k.exec(kubectlDrainOpts...)
+	return k.exec(ctx, kubectlDrainOpts...)
 }
 
-func (k *Kubectl) NodeUncordon() error {
+func (k *Kubectl) NodeCordon(ctx context.Context) error {
+	k.logger.Info("cordon node", slog.String("node", k.nodeName))
+	return k.exec(ctx, "cordon", k.nodeName)
+}
+
+func (k *Kubectl) NodeUncordon(ctx context.Context) error {
 	k.logger.Info("uncordon node", slog.String("node", k.nodeName))
-	return k.exec("uncordon", k.nodeName)
+	return k.exec(ctx, "uncordon", k.nodeName)
 }
 
-func (k *Kubectl) exec(args ...string) error {
+func (k *Kubectl) exec(ctx context.Context, args ...string) error {
 	if k.Conf.DryRun {
 		args = append(args, "--dry-run")
 	}
 
-	return k.runComand(exec.Command(k.Conf.KubectlPath, args...)) // #nosec G204
+	return k.runComand(exec.CommandContext(ctx, k.Conf.KubectlPath, args...)) // #nosec G204
 }
 
 func (k *Kubectl) runComand(cmd *exec.Cmd) (err error) {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
 )
 
 var (
@@ -12,22 +14,29 @@ var (
 	azureVmssNameRegexp       = regexp.MustCompile(`(?i)/providers/Microsoft.Compute/virtualMachineScaleSets/([^/]+)/.*`)
 	azureVmssInstanceIdRegexp = regexp.MustCompile(`(?i)/providers/Microsoft.Compute/virtualMachineScaleSets/[^/]+/virtualMachines/([^/]+)$`)
 	azureVmNameRegexp         = regexp.MustCompile(`(?i)/providers/Microsoft.Compute/virtualMachines/([^/]+)$`)
+	azureVmssResourceIdRegexp = regexp.MustCompile(`^(?i)/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft.Compute/virtualMachineScaleSets/([^/]+)$`)
+	azureVmssIdRegexp         = regexp.MustCompile(`^(?i)azure://(/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft.Compute/virtualMachineScaleSets/[^/]+)/virtualMachines/[^/]+$`)
 )
 
 type (
 	NodeInfo struct {
-		NodeName       string
-		NodeProviderId string
-		ProviderId     string
+		NodeName       string `json:"nodeName"`
+		NodeProviderId string `json:"nodeProviderId"`
+		ProviderId     string `json:"providerId"`
+
+		Subscription  string `json:"subscription"`
+		ResourceGroup string `json:"resourceGroup"`
 
-		Subscription  string
-		ResourceGroup string
+		IsVmss         bool   `json:"isVmss"`
+		IsVmssFlex     bool   `json:"isVmssFlex"`
+		VMScaleSetName string `json:"vmScaleSetName,omitempty"`
+		VMInstanceID   string `json:"vmInstanceId,omitempty"`
 
-		IsVmss         bool
-		VMScaleSetName string
-		VMInstanceID   string
+		// scale set can be in other resource group than its instances (VMSS Flex)
+		VMScaleSetSubscription  string `json:"vmScaleSetSubscription,omitempty"`
+		VMScaleSetResourceGroup string `json:"vmScaleSetResourceGroup,omitempty"`
 
-		VMname string
+		VMname string `json:"vmName,omitempty"`
 	}
 )
 
@@ -63,6 +72,8 @@ func ExtractNodeInfo(node *Node) (*NodeInfo, error) {
 		} else {
 			return nil, fmt.Errorf("unable to detect Azure VMScaleSetName from Node ProviderId (Azure resource ID): %v", nodeProviderId)
 		}
+		info.VMScaleSetSubscription = info.Subscription
+		info.VMScaleSetResourceGroup = info.ResourceGroup
 
 		// extract VmssInstanceId
 		if match := azureVmssInstanceIdRegexp.FindStringSubmatch(nodeProviderId); len(match) == 2 {
@@ -80,7 +91,92 @@ func ExtractNodeInfo(node *Node) (*NodeInfo, error) {
 		} else {
 			return nil, fmt.Errorf("unable to detect Azure VMname from Node ProviderId (Azure resource ID): %v", nodeProviderId)
 		}
+
+		// VMSS Flex instances are using VM provider IDs
+		if node.AzureVm != nil {
+			if err := info.ApplyAzureVm(node.AzureVm); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return &info, nil
 }
+
+// detects VMSS Flex instance using the virtualMachineScaleSet property of the Azure VM
+func (info *NodeInfo) ApplyAzureVm(vm *armcompute.VirtualMachine) error {
+	if vm.Properties == nil || vm.Properties.VirtualMachineScaleSet == nil || vm.Properties.VirtualMachineScaleSet.ID == nil {
+		return nil
+	}
+
+	vmssId := *vm.Properties.VirtualMachineScaleSet.ID
+	match := azureVmssResourceIdRegexp.FindStringSubmatch(vmssId)
+	if len(match) != 4 {
+		return fmt.Errorf("unable to detect Azure VMSS from VM virtualMachineScaleSet property: %v", vmssId)
+	}
+
+	info.IsVmss = true
+	info.IsVmssFlex = true
+	info.VMScaleSetSubscription = match[1]
+	info.VMScaleSetResourceGroup = match[2]
+	info.VMScaleSetName = match[3]
+	// VMSS Flex instances are addressed by VM name
+	info.VMInstanceID = info.VMname
+
+	return nil
+}
+
+// VMSS Flex instances don't report latestModelApplied, so VM size and image are compared with the scale set model
+// (other model changes, eg. extensions, tags or OS profile, are not detected)
+func VmssFlexLatestModelApplied(vm *armcompute.VirtualMachine, vmss *armcompute.VirtualMachineScaleSet) *bool {
+	if vm.Properties == nil || vmss.Properties == nil || vmss.Properties.VirtualMachineProfile == nil {
+		return nil
+	}
+
+	latestModelApplied := true
+
+	// VM size
+	if vmss.SKU != nil && vmss.SKU.Name != nil && vm.Properties.HardwareProfile != nil && vm.Properties.HardwareProfile.VMSize != nil {
+		if !strings.EqualFold(*vmss.SKU.Name, string(*vm.Properties.HardwareProfile.VMSize)) {
+			latestModelApplied = false
+		}
+	}
+
+	// image
+	var vmImage, vmssImage *armcompute.ImageReference
+	if vm.Properties.StorageProfile != nil {
+		vmImage = vm.Properties.StorageProfile.ImageReference
+	}
+	if vmss.Properties.VirtualMachineProfile.StorageProfile != nil {
+		vmssImage = vmss.Properties.VirtualMachineProfile.StorageProfile.ImageReference
+	}
+	if vmImage != nil && vmssImage != nil {
+		if imageReferenceString(vmImage) != imageReferenceString(vmssImage) {
+			latestModelApplied = false
+		}
+	}
+
+	return &latestModelApplied
+}
+
+func imageReferenceString(image *armcompute.ImageReference) string {
+	parts := []*string{
+		image.ID,
+		image.SharedGalleryImageID,
+		image.CommunityGalleryImageID,
+		image.Publisher,
+		image.Offer,
+		image.SKU,
+		image.Version,
+	}
+
+	ret := []string{}
+	for _, part := range parts {
+		val := ""
+		if part != nil {
+			val = strings.ToLower(*part)
+		}
+		ret = append(ret, val)
+	}
+	return strings.Join(ret, "|")
+}
@@ -0,0 +1,185 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testVmssId = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool1"
+	testVmId   = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+
+	testOtherVmssId = "/subscriptions/11111111-1111-1111-1111-111111111111/resourceGroups/rg-vmss/providers/Microsoft.Compute/virtualMachineScaleSets/pool2"
+)
+
+func TestExtractNodeInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		providerId string
+		azureVm    *armcompute.VirtualMachine
+		expectErr  bool
+		expect     NodeInfo
+	}{
+		{
+			name:       "vmss uniform",
+			providerId: "azure://" + testVmssId + "/virtualMachines/3",
+			expect:     NodeInfo{Subscription: "00000000-0000-0000-0000-000000000000", ResourceGroup: "rg", IsVmss: true, VMScaleSetName: "pool1", VMInstanceID: "3", VMScaleSetSubscription: "00000000-0000-0000-0000-000000000000", VMScaleSetResourceGroup: "rg"},
+		},
+		{
+			name:       "vm",
+			providerId: "azure://" + testVmId,
+			expect:     NodeInfo{Subscription: "00000000-0000-0000-0000-000000000000", ResourceGroup: "rg", VMname: "vm1"},
+		},
+		{
+			name:       "vm without scale set",
+			providerId: "azure://" + testVmId,
+			azureVm:    &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{}},
+			expect:     NodeInfo{Subscription: "00000000-0000-0000-0000-000000000000", ResourceGroup: "rg", VMname: "vm1"},
+		},
+		{
+			name:       "vmss flex",
+			providerId: "azure://" + testVmId,
+			azureVm: &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{
+				VirtualMachineScaleSet: &armcompute.SubResource{ID: to.Ptr(testVmssId)},
+			}},
+			expect: NodeInfo{Subscription: "00000000-0000-0000-0000-000000000000", ResourceGroup: "rg", IsVmss: true, IsVmssFlex: true, VMScaleSetName: "pool1", VMInstanceID: "vm1", VMname: "vm1", VMScaleSetSubscription: "00000000-0000-0000-0000-000000000000", VMScaleSetResourceGroup: "rg"},
+		},
+		{
+			name:       "vmss flex in other resource group",
+			providerId: "azure://" + testVmId,
+			azureVm: &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{
+				VirtualMachineScaleSet: &armcompute.SubResource{ID: to.Ptr(testOtherVmssId)},
+			}},
+			expect: NodeInfo{Subscription: "00000000-0000-0000-0000-000000000000", ResourceGroup: "rg", IsVmss: true, IsVmssFlex: true, VMScaleSetName: "pool2", VMInstanceID: "vm1", VMname: "vm1", VMScaleSetSubscription: "11111111-1111-1111-1111-111111111111", VMScaleSetResourceGroup: "rg-vmss"},
+		},
+		{
+			name:       "vmss flex with invalid scale set id",
+			providerId: "azure://" + testVmId,
+			azureVm: &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{
+				VirtualMachineScaleSet: &armcompute.SubResource{ID: to.Ptr("/subscriptions/x/resourceGroups/rg")},
+			}},
+			expectErr: true,
+		},
+		{
+			name:       "no azure provider",
+			providerId: "kind://docker/kind/kind-worker",
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &Node{
+				Node: &v1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+					Spec:       v1.NodeSpec{ProviderID: test.providerId},
+				},
+				AzureVm: test.azureVm,
+			}
+
+			info, err := ExtractNodeInfo(node)
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", *info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			test.expect.NodeName = "node1"
+			test.expect.NodeProviderId = test.providerId
+			test.expect.ProviderId = test.providerId[len("azure://"):]
+			if *info != test.expect {
+				t.Errorf("expected %+v, got %+v", test.expect, *info)
+			}
+		})
+	}
+}
+
+func TestVmssFlexLatestModelApplied(t *testing.T) {
+	image := func(version string) *armcompute.ImageReference {
+		return &armcompute.ImageReference{Publisher: to.Ptr("Canonical"), Offer: to.Ptr("ubuntu"), SKU: to.Ptr("22_04-lts"), Version: to.Ptr(version)}
+	}
+
+	newVm := func(size armcompute.VirtualMachineSizeTypes, image *armcompute.ImageReference) *armcompute.VirtualMachine {
+		return &armcompute.VirtualMachine{Properties: &armcompute.VirtualMachineProperties{
+			HardwareProfile: &armcompute.HardwareProfile{VMSize: to.Ptr(size)},
+			StorageProfile:  &armcompute.StorageProfile{ImageReference: image},
+		}}
+	}
+
+	newVmss := func(size string, image *armcompute.ImageReference) *armcompute.VirtualMachineScaleSet {
+		return &armcompute.VirtualMachineScaleSet{
+			SKU: &armcompute.SKU{Name: to.Ptr(size)},
+			Properties: &armcompute.VirtualMachineScaleSetProperties{
+				VirtualMachineProfile: &armcompute.VirtualMachineScaleSetVMProfile{
+					StorageProfile: &armcompute.VirtualMachineScaleSetStorageProfile{ImageReference: image},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		vm     *armcompute.VirtualMachine
+		vmss   *armcompute.VirtualMachineScaleSet
+		expect *bool
+	}{
+		{
+			name:   "same model",
+			vm:     newVm(armcompute.VirtualMachineSizeTypesStandardD2SV3, image("1.0.0")),
+			vmss:   newVmss("standard_d2s_v3", image("1.0.0")),
+			expect: to.Ptr(true),
+		},
+		{
+			name:   "different size",
+			vm:     newVm(armcompute.VirtualMachineSizeTypesStandardD2SV3, image("1.0.0")),
+			vmss:   newVmss("Standard_D4s_v3", image("1.0.0")),
+			expect: to.Ptr(false),
+		},
+		{
+			name:   "different image version",
+			vm:     newVm(armcompute.VirtualMachineSizeTypesStandardD2SV3, image("1.0.0")),
+			vmss:   newVmss("Standard_D2s_v3", image("1.0.1")),
+			expect: to.Ptr(false),
+		},
+		{
+			name:   "image not set",
+			vm:     newVm(armcompute.VirtualMachineSizeTypesStandardD2SV3, nil),
+			vmss:   newVmss("Standard_D2s_v3", image("1.0.1")),
+			expect: to.Ptr(true),
+		},
+		{
+			name:   "no vm properties",
+			vm:     &armcompute.VirtualMachine{},
+			vmss:   newVmss("Standard_D2s_v3", image("1.0.0")),
+			expect: nil,
+		},
+		{
+			name:   "no scale set model",
+			vm:     newVm(armcompute.VirtualMachineSizeTypesStandardD2SV3, image("1.0.0")),
+			vmss:   &armcompute.VirtualMachineScaleSet{Properties: &armcompute.VirtualMachineScaleSetProperties{}},
+			expect: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ret := VmssFlexLatestModelApplied(test.vm, test.vmss)
+			switch {
+			case test.expect == nil && ret != nil:
+				t.Errorf("expected nil, got %v", *ret)
+			case test.expect != nil && ret == nil:
+				t.Errorf("expected %v, got nil", *test.expect)
+			case test.expect != nil && *ret != *test.expect:
+				t.Errorf("expected %v, got %v", *test.expect, *ret)
+			}
+		})
+	}
+}
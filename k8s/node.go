@@ -17,6 +17,13 @@ import (
 const (
 	ClusterAutoscaleScaleDownExpireAnnotation  = "cluster-autoscaler.kubernetes.io/scale-down-disabled-expire"
 	ClusterAutoscaleScaleDownDisableAnnotation = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+
+	// non-graceful node shutdown handling (force pod deletion and volume detach)
+	OutOfServiceTaintKey   = "node.kubernetes.io/out-of-service"
+	OutOfServiceTaintValue = "nodeshutdown"
+
+	// timeout of node patches, not bound to cancellation of the caller
+	NodePatchTimeout = 30 * time.Second
 )
 
 type (
@@ -24,13 +31,20 @@ type (
 		*v1.Node
 		Client    *kubernetes.Clientset
 		AzureVmss *armcompute.VirtualMachineScaleSetVM
+		AzureVm   *armcompute.VirtualMachine
+
+		// scale set model of VMSS (uniform and flex) instance
+		AzureScaleSet *armcompute.VirtualMachineScaleSet
+
+		// called after each patch of the node (eg. for audit logging)
+		OnPatch func(ctx context.Context, node *Node, patches []JsonPatch, err error)
 	}
 )
 
-func (n *Node) Cleanup() error {
+func (n *Node) Cleanup(ctx context.Context) error {
 	if lockDuration, exists := n.AnnotationLockCheck(ClusterAutoscaleScaleDownExpireAnnotation); exists {
 		if lockDuration == nil || lockDuration.Seconds() <= 0 {
-			if err := n.AnnotationRemove(ClusterAutoscaleScaleDownExpireAnnotation, ClusterAutoscaleScaleDownDisableAnnotation); err != nil {
+			if err := n.AnnotationRemove(ctx, ClusterAutoscaleScaleDownExpireAnnotation, ClusterAutoscaleScaleDownDisableAnnotation); err != nil {
 				return err
 			}
 		}
@@ -59,22 +73,205 @@ func (n *Node) GetHealthStatus() (status bool, lastHeartbeat time.Time) {
 	return
 }
 
+// check if Azure resource was found in inventory cache
+func (n *Node) HasAzureResource() bool {
+	return n.AzureVmss != nil || n.AzureVm != nil
+}
+
+// provisioning state of Azure resource from inventory cache
+func (n *Node) AzureProvisioningState() *string {
+	switch {
+	case n.AzureVmss != nil && n.AzureVmss.Properties != nil:
+		return n.AzureVmss.Properties.ProvisioningState
+	case n.AzureVm != nil && n.AzureVm.Properties != nil:
+		return n.AzureVm.Properties.ProvisioningState
+	}
+	return nil
+}
+
+// check if Azure resource is running the latest scale set model, nil if unknown or not a VMSS instance
+func (n *Node) AzureLatestModelApplied() *bool {
+	switch {
+	case n.AzureVmss != nil && n.AzureVmss.Properties != nil:
+		return n.AzureVmss.Properties.LatestModelApplied
+	case n.AzureVm != nil && n.AzureScaleSet != nil:
+		return VmssFlexLatestModelApplied(n.AzureVm, n.AzureScaleSet)
+	}
+	return nil
+}
+
+// protection policy of VMSS instance from inventory cache
+func (n *Node) AzureProtectionPolicy() (protectFromScaleIn, protectFromScaleSetActions bool) {
+	if n.AzureVmss != nil && n.AzureVmss.Properties != nil && n.AzureVmss.Properties.ProtectionPolicy != nil {
+		policy := n.AzureVmss.Properties.ProtectionPolicy
+		protectFromScaleIn = policy.ProtectFromScaleIn != nil && *policy.ProtectFromScaleIn
+		protectFromScaleSetActions = policy.ProtectFromScaleSetActions != nil && *policy.ProtectFromScaleSetActions
+	}
+	return
+}
+
+// upgrade policy mode (Manual, Automatic, Rolling) of scale set from inventory cache, empty if unknown
+func (n *Node) AzureUpgradePolicyMode() string {
+	if n.AzureScaleSet != nil && n.AzureScaleSet.Properties != nil && n.AzureScaleSet.Properties.UpgradePolicy != nil && n.AzureScaleSet.Properties.UpgradePolicy.Mode != nil {
+		return string(*n.AzureScaleSet.Properties.UpgradePolicy.Mode)
+	}
+	return ""
+}
+
+// instance view statuses of Azure resource from inventory cache
+func (n *Node) azureInstanceViewStatuses() []*armcompute.InstanceViewStatus {
+	switch {
+	case n.AzureVmss != nil && n.AzureVmss.Properties != nil && n.AzureVmss.Properties.InstanceView != nil:
+		return n.AzureVmss.Properties.InstanceView.Statuses
+	case n.AzureVm != nil && n.AzureVm.Properties != nil && n.AzureVm.Properties.InstanceView != nil:
+		return n.AzureVm.Properties.InstanceView.Statuses
+	}
+	return nil
+}
+
+// power state of Azure resource (eg. running, stopped, deallocated) from inventory cache
+func (n *Node) AzurePowerState() string {
+	for _, status := range n.azureInstanceViewStatuses() {
+		if status.Code != nil && strings.HasPrefix(*status.Code, "PowerState/") {
+			return strings.TrimPrefix(*status.Code, "PowerState/")
+		}
+	}
+	return ""
+}
+
+// VM agent status of Azure resource from inventory cache
+func (n *Node) AzureVmAgentStatus() string {
+	var vmAgent *armcompute.VirtualMachineAgentInstanceView
+	switch {
+	case n.AzureVmss != nil && n.AzureVmss.Properties != nil && n.AzureVmss.Properties.InstanceView != nil:
+		vmAgent = n.AzureVmss.Properties.InstanceView.VMAgent
+	case n.AzureVm != nil && n.AzureVm.Properties != nil && n.AzureVm.Properties.InstanceView != nil:
+		vmAgent = n.AzureVm.Properties.InstanceView.VMAgent
+	}
+
+	if vmAgent != nil {
+		for _, status := range vmAgent.Statuses {
+			if status.DisplayStatus != nil {
+				return *status.DisplayStatus
+			}
+		}
+	}
+	return ""
+}
+
+// extension statuses of Azure resource from inventory cache
+func (n *Node) AzureExtensionStatuses() []*armcompute.VirtualMachineExtensionInstanceView {
+	switch {
+	case n.AzureVmss != nil && n.AzureVmss.Properties != nil && n.AzureVmss.Properties.InstanceView != nil:
+		return n.AzureVmss.Properties.InstanceView.Extensions
+	case n.AzureVm != nil && n.AzureVm.Properties != nil && n.AzureVm.Properties.InstanceView != nil:
+		return n.AzureVm.Properties.InstanceView.Extensions
+	}
+	return nil
+}
+
+// delete node object
+func (n *Node) Delete(ctx context.Context) error {
+	return n.Client.CoreV1().Nodes().Delete(ctx, n.Name, metav1.DeleteOptions{})
+}
+
+// force delete all pods scheduled on node (eg. node is gone and pods are stuck in terminating state)
+func (n *Node) ForceDeletePods(ctx context.Context) (count int, err error) {
+	pods, err := n.Pods(ctx)
+	if err != nil {
+		return
+	}
+
+	gracePeriod := int64(0)
+	deleteOpts := metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod}
+	for _, pod := range pods {
+		if err = n.Client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, deleteOpts); err != nil {
+			return
+		}
+		count++
+	}
+
+	return
+}
+
+func (n *Node) HasTaint(key string) bool {
+	for _, taint := range n.Spec.Taints {
+		if taint.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// add or replace taint (and set annotations in the same patch)
+func (n *Node) TaintSet(ctx context.Context, taint v1.Taint, annotations map[string]string) error {
+	taints := []v1.Taint{taint}
+	for _, val := range n.Spec.Taints {
+		if val.Key != taint.Key {
+			taints = append(taints, val)
+		}
+	}
+
+	return n.taintsApply(ctx, taints, annotations, nil)
+}
+
+// remove taint (and annotations in the same patch)
+func (n *Node) TaintRemove(ctx context.Context, key string, annotations ...string) error {
+	taints := []v1.Taint{}
+	for _, val := range n.Spec.Taints {
+		if val.Key != key {
+			taints = append(taints, val)
+		}
+	}
+
+	return n.taintsApply(ctx, taints, nil, annotations)
+}
+
+func (n *Node) taintsApply(ctx context.Context, taints []v1.Taint, setAnnotations map[string]string, removeAnnotations []string) error {
+	patches := []JsonPatch{JsonPatchObject{
+		Op:    "add",
+		Path:  "/spec/taints",
+		Value: taints,
+	}}
+
+	for name, value := range setAnnotations {
+		patches = append(patches, JsonPatchString{
+			Op:    "add",
+			Path:  fmt.Sprintf("/metadata/annotations/%s", PatchPathEsacpe(name)),
+			Value: value,
+		})
+	}
+
+	for _, name := range removeAnnotations {
+		if n.AnnotationExists(name) {
+			patches = append(patches, JsonPatchString{
+				Op:   "remove",
+				Path: fmt.Sprintf("/metadata/annotations/%s", PatchPathEsacpe(name)),
+			})
+		}
+	}
+
+	_, err := n.PatchSetApply(ctx, patches)
+	return err
+}
+
 func (n *Node) AnnotationExists(name string) bool {
 	_, exists := n.Annotations[name]
 	return exists
 }
 
-func (n *Node) AnnotationSet(name, value string) (err error) {
+func (n *Node) AnnotationSet(ctx context.Context, name, value string) (err error) {
 	patches := []JsonPatch{JsonPatchString{
 		Op:    "replace",
 		Path:  fmt.Sprintf("/metadata/annotations/%s", PatchPathEsacpe(name)),
 		Value: value,
 	}}
 
-	return n.PatchSetApply(patches)
+	_, err = n.PatchSetApply(ctx, patches)
+	return err
 }
 
-func (n *Node) AnnotationsSet(annotations map[string]string) (err error) {
+func (n *Node) AnnotationsSet(ctx context.Context, annotations map[string]string) (err error) {
 	patches := []JsonPatch{}
 
 	for name, value := range annotations {
@@ -85,10 +282,11 @@ func (n *Node) AnnotationsSet(annotations map[string]string) (err error) {
 		})
 	}
 
-	return n.PatchSetApply(patches)
+	_, err = n.PatchSetApply(ctx, patches)
+	return err
 }
 
-func (n *Node) AnnotationLockSet(name string, dur time.Duration, autoscalerScaledownTimeLock time.Duration) error {
+func (n *Node) AnnotationLockSet(ctx context.Context, name string, dur time.Duration, autoscalerScaledownTimeLock time.Duration) error {
 	value := time.Now().Add(dur).Format(time.RFC3339)
 	patches := []JsonPatch{JsonPatchString{
 		Op:    "replace",
@@ -115,19 +313,21 @@ func (n *Node) AnnotationLockSet(name string, dur time.Duration, autoscalerScale
 		})
 	}
 
-	return n.PatchSetApply(patches)
+	_, err := n.PatchSetApply(ctx, patches)
+	return err
 }
 
-func (n *Node) AnnotationLockRemove(name string) error {
+func (n *Node) AnnotationLockRemove(ctx context.Context, name string) error {
 	patches := []JsonPatch{JsonPatchString{
 		Op:   "remove",
 		Path: fmt.Sprintf("/metadata/annotations/%s", PatchPathEsacpe(name)),
 	}}
 
-	return n.PatchSetApply(patches)
+	_, err := n.PatchSetApply(ctx, patches)
+	return err
 }
 
-func (n *Node) AnnotationRemove(names ...string) (err error) {
+func (n *Node) AnnotationRemove(ctx context.Context, names ...string) (err error) {
 	patches := []JsonPatch{}
 	for _, name := range names {
 		patches = append(patches, JsonPatchString{
@@ -136,7 +336,8 @@ func (n *Node) AnnotationRemove(names ...string) (err error) {
 		})
 	}
 
-	return n.PatchSetApply(patches)
+	_, err = n.PatchSetApply(ctx, patches)
+	return err
 }
 
 func (n *Node) AnnotationLockCheck(name string) (dur *time.Duration, exists bool) {
@@ -157,19 +358,23 @@ func (n *Node) AnnotationLockCheck(name string) (dur *time.Duration, exists bool
 	return
 }
 
-func (n *Node) PatchSetApply(patches []JsonPatch) (err error) {
-	ctx := context.Background()
-	patchBytes, patchErr := json.Marshal(patches)
-	if patchErr != nil {
-		err = patchErr
-		return
+// apply patches to node and return patched node object
+// the local object is not modified as it is shared between goroutines, it is updated by the node watch
+// patches are applied even if ctx is cancelled (eg. locks are persisted on shutdown)
+func (n *Node) PatchSetApply(ctx context.Context, patches []JsonPatch) (node *v1.Node, err error) {
+	if n.OnPatch != nil {
+		defer func() {
+			n.OnPatch(ctx, n, patches, err)
+		}()
 	}
 
-	_, k8sError := n.Client.CoreV1().Nodes().Patch(ctx, n.Name, types.JSONPatchType, patchBytes, metav1.PatchOptions{})
-	if k8sError != nil {
-		err = k8sError
-		return
+	patchBytes, err := json.Marshal(patches)
+	if err != nil {
+		return nil, err
 	}
 
-	return
+	patchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), NodePatchTimeout)
+	defer cancel()
+
+	return n.Client.CoreV1().Nodes().Patch(patchCtx, n.Name, types.JSONPatchType, patchBytes, metav1.PatchOptions{})
 }
@@ -3,6 +3,7 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
 	"time"
@@ -18,24 +19,52 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
+const (
+	AzureInventoryBackendArm           = "arm"
+	AzureInventoryBackendResourceGraph = "resourcegraph"
+)
+
 type (
 	NodeList struct {
-		NodeLabelSelector string
-		Client            *kubernetes.Clientset
-		AzureCacheTimeout *time.Duration
+		NodeLabelSelector     string
+		Client                *kubernetes.Clientset
+		AzureCacheTimeout     *time.Duration
+		AzureInventoryBackend string
 
 		AzureClient *armclient.ArmClient
 
+		// called when the ready condition of a node changes (or a node is added)
+		OnConditionChange func(node *Node)
+
+		// called after each Azure inventory refresh
+		OnAzureCacheRefresh func(stats AzureCacheRefreshStats, err error)
+
+		// called when a node is deleted
+		OnNodeDelete func(nodeName string)
+
+		// called after each patch of a node
+		OnNodePatch func(ctx context.Context, node *Node, patches []JsonPatch, err error)
+
 		UserAgent string
 
 		Logger *slogger.Logger
 
-		nodeWatcher watch.Interface
-		azureCache  *cache.Cache
-		ctx         context.Context
-		list        map[string]*Node
-		lock        sync.Mutex
-		isStopped   bool
+		nodeWatcher           watch.Interface
+		azureCache            *cache.Cache
+		azureCacheLock        sync.Mutex
+		azureCacheLastRefresh time.Time
+		azureCacheStats       AzureCacheRefreshStats
+		ctx                   context.Context
+		list                  map[string]*Node
+		lock                  sync.Mutex
+		isStopped             bool
+	}
+
+	AzureCacheRefreshStats struct {
+		Backend   string
+		Duration  time.Duration
+		Requests  int
+		Resources int
 	}
 )
 
@@ -46,7 +75,8 @@ func (n *NodeList) Start() {
 		n.AzureCacheTimeout = &timeout
 	}
 
-	n.azureCache = cache.New(*n.AzureCacheTimeout, 1*time.Minute)
+	// keep entries longer than refresh interval, stale entries (eg. deleted VMs) are removed afterwards
+	n.azureCache = cache.New(*n.AzureCacheTimeout*2, 1*time.Minute)
 
 	go func() {
 		for {
@@ -71,6 +101,9 @@ func (n *NodeList) Stop() {
 
 func (n *NodeList) ClearAzureCache() {
 	n.Logger.Info("invalidating azure cache")
+	n.azureCacheLock.Lock()
+	n.azureCacheLastRefresh = time.Time{}
+	n.azureCacheLock.Unlock()
 	n.azureCache.Flush()
 }
 
@@ -98,31 +131,25 @@ func (n *NodeList) startNodeWatch() error {
 		switch res.Type {
 		// node added
 		case watch.Added:
-			n.lock.Lock()
 			if node, ok := res.Object.(*corev1.Node); ok {
-				node := &Node{Node: node, Client: n.Client}
-				if node.IsAzureProvider() {
-					n.list[node.Name] = node
-				}
+				n.updateNode(&Node{Node: node, Client: n.Client, OnPatch: n.OnNodePatch})
 			}
-			n.lock.Unlock()
 		// node deleted
 		case watch.Deleted:
-			n.lock.Lock()
 			if node, ok := res.Object.(*corev1.Node); ok {
+				n.lock.Lock()
 				delete(n.list, node.Name)
+				n.lock.Unlock()
+
+				if n.OnNodeDelete != nil {
+					n.OnNodeDelete(node.Name)
+				}
 			}
-			n.lock.Unlock()
 		// node modified
 		case watch.Modified:
-			n.lock.Lock()
 			if node, ok := res.Object.(*corev1.Node); ok {
-				node := &Node{Node: node, Client: n.Client}
-				if node.IsAzureProvider() {
-					n.list[node.Name] = node
-				}
+				n.updateNode(&Node{Node: node, Client: n.Client, OnPatch: n.OnNodePatch})
 			}
-			n.lock.Unlock()
 		case watch.Error:
 			n.Logger.Errorf("go watch error event %v", res.Object)
 		}
@@ -131,10 +158,35 @@ func (n *NodeList) startNodeWatch() error {
 	return fmt.Errorf("terminated")
 }
 
-func (n *NodeList) Cleanup() {
+// updates node in list and triggers OnConditionChange if the ready condition changed
+func (n *NodeList) updateNode(node *Node) {
+	if !node.IsAzureProvider() {
+		return
+	}
+
+	n.lock.Lock()
+	previousNode, exists := n.list[node.Name]
+	n.list[node.Name] = node
+	n.lock.Unlock()
+
+	if n.OnConditionChange == nil {
+		return
+	}
+
+	nodeIsHealthy, _ := node.GetHealthStatus()
+	if exists {
+		if previousNodeIsHealthy, _ := previousNode.GetHealthStatus(); previousNodeIsHealthy == nodeIsHealthy {
+			return
+		}
+	}
+
+	n.OnConditionChange(node)
+}
+
+func (n *NodeList) Cleanup(ctx context.Context) {
 	for _, v := range n.NodeList() {
 		node := v
-		err := node.Cleanup()
+		err := node.Cleanup(ctx)
 		if err != nil {
 			n.Logger.Error(err.Error())
 		}
@@ -153,30 +205,124 @@ func (n *NodeList) NodeList() (list []*Node) {
 	return
 }
 
-func (n *NodeList) NodeListWithAzure() (list []*Node, err error) {
-	list = n.NodeList()
+func (n *NodeList) Node(name string) *Node {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.list[name]
+}
 
+func (n *NodeList) NodeListWithAzure() (list []*Node, err error) {
 	if err := n.refreshAzureCache(); err != nil {
 		return nil, err
 	}
 
-	for index, node := range list {
-		providerID := strings.ToLower(node.Spec.ProviderID)
-		if azureResource, exists := n.azureCache.Get(providerID); exists {
-			node.AzureVmss = azureResource.(*armcompute.VirtualMachineScaleSetVM)
+	list = []*Node{}
+	for _, node := range n.NodeList() {
+		list = append(list, n.nodeWithAzure(node))
+	}
+	return
+}
+
+// returns node with Azure resources from inventory cache (cache is not refreshed)
+func (n *NodeList) NodeWithAzure(name string) *Node {
+	if node := n.Node(name); node != nil {
+		return n.nodeWithAzure(node)
+	}
+	return nil
+}
+
+// returns copy of node with Azure resources from inventory cache
+func (n *NodeList) nodeWithAzure(node *Node) *Node {
+	node = &Node{Node: node.Node, Client: node.Client, OnPatch: node.OnPatch}
+
+	providerID := strings.ToLower(node.Spec.ProviderID)
+	if azureResource, exists := n.azureCache.Get(providerID); exists {
+		switch resource := azureResource.(type) {
+		case *armcompute.VirtualMachineScaleSetVM:
+			node.AzureVmss = resource
+		case *armcompute.VirtualMachine:
+			node.AzureVm = resource
 		}
+	}
 
-		list[index] = node
+	// scale set model (VMSS uniform and flex)
+	if scaleSetId := azureNodeScaleSetId(node); scaleSetId != "" {
+		if scaleSet, exists := n.azureCache.Get(scaleSetId); exists {
+			node.AzureScaleSet, _ = scaleSet.(*armcompute.VirtualMachineScaleSet)
+		}
 	}
-	return
+
+	return node
+}
+
+// returns (lowercase) ID of scale set of node, empty if node is not part of a scale set
+func azureNodeScaleSetId(node *Node) string {
+	// VMSS Flex instance
+	if vm := node.AzureVm; vm != nil && vm.Properties != nil && vm.Properties.VirtualMachineScaleSet != nil && vm.Properties.VirtualMachineScaleSet.ID != nil {
+		return strings.ToLower(*vm.Properties.VirtualMachineScaleSet.ID)
+	}
+
+	// VMSS uniform instance
+	if match := azureVmssIdRegexp.FindStringSubmatch(node.Spec.ProviderID); len(match) == 2 {
+		return strings.ToLower(match[1])
+	}
+
+	return ""
 }
 
+// refresh Azure inventory cache, only refreshed if cache is older than AzureCacheTimeout
 func (n *NodeList) refreshAzureCache() error {
+	n.azureCacheLock.Lock()
+	defer n.azureCacheLock.Unlock()
+
+	if time.Since(n.azureCacheLastRefresh) < *n.AzureCacheTimeout {
+		n.Logger.Debugf("using cached azure inventory from %s", n.azureCacheLastRefresh.String())
+		return nil
+	}
+
+	start := time.Now()
+	n.azureCacheStats = AzureCacheRefreshStats{Backend: n.AzureInventoryBackend}
+
 	n.Logger.Infof("refresh azure cache")
+	err := n.refreshAzureCacheFromBackend()
+	if err == nil {
+		err = n.refreshAzureScaleSetCache()
+	}
+	n.azureCacheStats.Duration = time.Since(start)
+
+	if n.OnAzureCacheRefresh != nil {
+		n.OnAzureCacheRefresh(n.azureCacheStats, err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	n.azureCacheLastRefresh = time.Now()
+
+	return nil
+}
+
+func (n *NodeList) refreshAzureCacheFromBackend() error {
+	if n.AzureInventoryBackend == AzureInventoryBackendResourceGraph {
+		err := n.refreshAzureResourceGraphCache()
+		if err == nil {
+			return nil
+		}
+
+		// fallback to ARM list calls
+		n.Logger.Warn("unable to refresh azure cache using ResourceGraph, falling back to ARM", slog.Any("error", err))
+		n.azureCacheStats.Backend = AzureInventoryBackendArm
+	}
+
 	if err := n.refreshAzureVmssCache(); err != nil {
 		return err
 	}
 
+	if err := n.refreshAzureVmCache(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -192,10 +338,14 @@ func (n *NodeList) refreshAzureVmssCache() error {
 			return err
 		}
 
-		pager := vmssVmClient.NewListPager(vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, nil)
+		listOpts := armcompute.VirtualMachineScaleSetVMsClientListOptions{
+			Expand: to.StringPtr("instanceView"),
+		}
+		pager := vmssVmClient.NewListPager(vmssInfo.ResourceGroup, vmssInfo.VMScaleSetName, &listOpts)
 
 		for pager.More() {
 			result, err := pager.NextPage(n.ctx)
+			n.azureCacheStats.Requests++
 			if err != nil {
 				return err
 			}
@@ -207,8 +357,83 @@ func (n *NodeList) refreshAzureVmssCache() error {
 				)
 
 				n.azureCache.SetDefault(providerID, vmssInstance)
+				n.azureCacheStats.Resources++
+			}
+		}
+	}
+
+	return nil
+}
+
+func (n *NodeList) refreshAzureVmCache() error {
+	vmList, err := n.GetAzureVmList()
+	if err != nil {
+		return err
+	}
+
+	clients := map[string]*armcompute.VirtualMachinesClient{}
+	for _, vmInfo := range vmList {
+		client, exists := clients[vmInfo.Subscription]
+		if !exists {
+			client, err = armcompute.NewVirtualMachinesClient(vmInfo.Subscription, n.AzureClient.GetCred(), n.AzureClient.NewArmClientOptions())
+			if err != nil {
+				return err
+			}
+			clients[vmInfo.Subscription] = client
+		}
+
+		expand := armcompute.InstanceViewTypesInstanceView
+		getOpts := armcompute.VirtualMachinesClientGetOptions{
+			Expand: &expand,
+		}
+		result, err := client.Get(n.ctx, vmInfo.ResourceGroup, vmInfo.VMname, &getOpts)
+		n.azureCacheStats.Requests++
+		if err != nil {
+			// single VMs are fetched one by one, so don't fail whole inventory
+			n.Logger.Warn("unable to fetch Azure VM", slog.String("node", vmInfo.NodeName), slog.Any("error", err))
+			continue
+		}
+
+		n.azureCache.SetDefault(strings.ToLower(vmInfo.NodeProviderId), &result.VirtualMachine)
+		n.azureCacheStats.Resources++
+	}
+
+	return nil
+}
+
+// fetch scale set models (needed for upgrade policy and VMSS Flex model drift detection)
+func (n *NodeList) refreshAzureScaleSetCache() error {
+	scaleSetIds := map[string]bool{}
+	for _, node := range n.NodeList() {
+		if scaleSetId := azureNodeScaleSetId(n.nodeWithAzure(node)); scaleSetId != "" {
+			scaleSetIds[scaleSetId] = true
+		}
+	}
+
+	clients := map[string]*armcompute.VirtualMachineScaleSetsClient{}
+	for scaleSetId := range scaleSetIds {
+		resourceInfo, err := armclient.ParseResourceId(scaleSetId)
+		if err != nil {
+			return err
+		}
+
+		client, exists := clients[resourceInfo.Subscription]
+		if !exists {
+			client, err = armcompute.NewVirtualMachineScaleSetsClient(resourceInfo.Subscription, n.AzureClient.GetCred(), n.AzureClient.NewArmClientOptions())
+			if err != nil {
+				return err
 			}
+			clients[resourceInfo.Subscription] = client
+		}
+
+		result, err := client.Get(n.ctx, resourceInfo.ResourceGroup, resourceInfo.ResourceName, nil)
+		n.azureCacheStats.Requests++
+		if err != nil {
+			return err
 		}
+
+		n.azureCache.SetDefault(scaleSetId, &result.VirtualMachineScaleSet)
+		n.azureCacheStats.Resources++
 	}
 
 	return nil
@@ -216,8 +441,9 @@ func (n *NodeList) refreshAzureVmssCache() error {
 
 func (n *NodeList) NodeCountByProvisionState(provisionState string) (count int) {
 	for _, node := range n.NodeList() {
-		if node.AzureVmss != nil && node.AzureVmss.Properties.ProvisioningState != nil {
-			if strings.EqualFold(to.String(node.AzureVmss.Properties.ProvisioningState), provisionState) {
+		node = n.nodeWithAzure(node)
+		if state := node.AzureProvisioningState(); state != nil {
+			if strings.EqualFold(*state, provisionState) {
 				count++
 			}
 		}
@@ -225,6 +451,27 @@ func (n *NodeList) NodeCountByProvisionState(provisionState string) (count int)
 	return
 }
 
+func (n *NodeList) GetAzureVmList() (vmList map[string]*NodeInfo, err error) {
+	vmList = map[string]*NodeInfo{}
+
+	for _, node := range n.NodeList() {
+		if node.IsAzureProvider() {
+			// parse node information from provider ID
+			nodeInfo, parseErr := ExtractNodeInfo(node)
+			if parseErr != nil {
+				err = parseErr
+				return
+			}
+
+			if !nodeInfo.IsVmss {
+				vmList[strings.ToLower(nodeInfo.ProviderId)] = nodeInfo
+			}
+		}
+	}
+
+	return
+}
+
 func (n *NodeList) GetAzureVmssList() (vmssList map[string]*NodeInfo, err error) {
 	vmssList = map[string]*NodeInfo{}
 
@@ -237,7 +484,8 @@ func (n *NodeList) GetAzureVmssList() (vmssList map[string]*NodeInfo, err error)
 				return
 			}
 
-			if nodeInfo.IsVmss {
+			// VMSS Flex instances are VMs and cached with the VM list
+			if nodeInfo.IsVmss && !nodeInfo.IsVmssFlex {
 				vmssKey := fmt.Sprintf(
 					"%s/%s/%s",
 					nodeInfo.Subscription,
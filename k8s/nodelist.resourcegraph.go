@@ -0,0 +1,189 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+)
+
+const (
+	azureResourceGraphInventoryQuery = `
+union
+	(resources | where type =~ "microsoft.compute/virtualmachines"),
+	(computeresources | where type =~ "microsoft.compute/virtualmachinescalesets/virtualmachines")
+| where tolower(strcat("/subscriptions/", subscriptionId, "/resourceGroups/", resourceGroup)) in (%s)
+`
+
+	azureResourceGraphPageSize = 1000
+)
+
+// refresh Azure inventory cache (VMs and VMSS instances) using one ResourceGraph query
+func (n *NodeList) refreshAzureResourceGraphCache() error {
+	subscriptions := map[string]string{}
+	// resource group IDs, names are only unique within a subscription
+	resourceGroups := map[string]string{}
+
+	vmssList, err := n.GetAzureVmssList()
+	if err != nil {
+		return err
+	}
+
+	vmList, err := n.GetAzureVmList()
+	if err != nil {
+		return err
+	}
+
+	for _, nodeInfo := range vmssList {
+		subscriptions[strings.ToLower(nodeInfo.Subscription)] = nodeInfo.Subscription
+		resourceGroupId := strings.ToLower(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", nodeInfo.Subscription, nodeInfo.ResourceGroup))
+		resourceGroups[resourceGroupId] = fmt.Sprintf("%q", resourceGroupId)
+	}
+
+	for _, nodeInfo := range vmList {
+		subscriptions[strings.ToLower(nodeInfo.Subscription)] = nodeInfo.Subscription
+		resourceGroupId := strings.ToLower(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", nodeInfo.Subscription, nodeInfo.ResourceGroup))
+		resourceGroups[resourceGroupId] = fmt.Sprintf("%q", resourceGroupId)
+	}
+
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	subscriptionList := []string{}
+	for _, subscription := range subscriptions {
+		subscriptionList = append(subscriptionList, subscription)
+	}
+
+	resourceGroupList := []string{}
+	for _, resourceGroup := range resourceGroups {
+		resourceGroupList = append(resourceGroupList, resourceGroup)
+	}
+
+	query := fmt.Sprintf(azureResourceGraphInventoryQuery, strings.Join(resourceGroupList, ", "))
+	result, err := n.executeAzureResourceGraphQuery(query, subscriptionList)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range result {
+		resourceType, _ := row["type"].(string)
+		resourceId, _ := row["id"].(string)
+		providerID := fmt.Sprintf("azure://%s", strings.ToLower(resourceId))
+
+		rowJson, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(resourceType) {
+		case "microsoft.compute/virtualmachinescalesets/virtualmachines":
+			vmssInstance := armcompute.VirtualMachineScaleSetVM{}
+			if err := json.Unmarshal(rowJson, &vmssInstance); err != nil {
+				return err
+			}
+
+			if vmssInstance.Properties != nil && vmssInstance.Properties.InstanceView == nil {
+				if statuses := resourceGraphPowerStateStatuses(row); statuses != nil {
+					vmssInstance.Properties.InstanceView = &armcompute.VirtualMachineScaleSetVMInstanceView{Statuses: statuses}
+				}
+			}
+
+			n.azureCache.SetDefault(providerID, &vmssInstance)
+		case "microsoft.compute/virtualmachines":
+			if _, exists := vmList[strings.ToLower(strings.TrimPrefix(providerID, "azure://"))]; !exists {
+				// VM is not a node
+				continue
+			}
+
+			vm := armcompute.VirtualMachine{}
+			if err := json.Unmarshal(rowJson, &vm); err != nil {
+				return err
+			}
+
+			if vm.Properties != nil && vm.Properties.InstanceView == nil {
+				if statuses := resourceGraphPowerStateStatuses(row); statuses != nil {
+					vm.Properties.InstanceView = &armcompute.VirtualMachineInstanceView{Statuses: statuses}
+				}
+			}
+
+			n.azureCache.SetDefault(providerID, &vm)
+		default:
+			continue
+		}
+
+		n.azureCacheStats.Resources++
+	}
+
+	return nil
+}
+
+// execute ResourceGraph query and fetch all pages (using skip token), every request is counted
+func (n *NodeList) executeAzureResourceGraphQuery(query string, subscriptions []string) ([]map[string]interface{}, error) {
+	list := []map[string]interface{}{}
+
+	client, err := armresourcegraph.NewClient(n.AzureClient.GetCred(), n.AzureClient.NewArmClientOptions())
+	if err != nil {
+		return list, err
+	}
+
+	resultFormat := armresourcegraph.ResultFormatObjectArray
+	top := int32(azureResourceGraphPageSize)
+	request := armresourcegraph.QueryRequest{
+		Query: &query,
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: &resultFormat,
+			Top:          &top,
+		},
+	}
+	for _, subscription := range subscriptions {
+		request.Subscriptions = append(request.Subscriptions, &subscription)
+	}
+
+	for {
+		n.azureCacheStats.Requests++
+		result, err := client.Resources(n.ctx, request, nil)
+		if err != nil {
+			return list, err
+		}
+
+		if rows, ok := result.Data.([]interface{}); ok {
+			for _, row := range rows {
+				if rowData, ok := row.(map[string]interface{}); ok {
+					list = append(list, rowData)
+				}
+			}
+		}
+
+		if result.SkipToken == nil || *result.SkipToken == "" {
+			break
+		}
+		request.Options.SkipToken = result.SkipToken
+	}
+
+	return list, nil
+}
+
+// ResourceGraph only provides the power state (properties.extended.instanceView.powerState) instead of the full instance view
+func resourceGraphPowerStateStatuses(row map[string]interface{}) []*armcompute.InstanceViewStatus {
+	path := []string{"properties", "extended", "instanceView", "powerState"}
+
+	var current interface{} = row
+	for _, key := range path {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = obj[key]
+	}
+
+	if powerState, ok := current.(map[string]interface{}); ok {
+		if code, ok := powerState["code"].(string); ok {
+			return []*armcompute.InstanceViewStatus{{Code: &code}}
+		}
+	}
+
+	return nil
+}
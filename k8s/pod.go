@@ -0,0 +1,114 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// pod should not be drained before this time (RFC3339)
+	PodDrainNotBeforeAnnotation = "autopilot.webdevops.io/drain-not-before"
+	// wait for pod completion up to this duration after pod start (eg. 4h)
+	PodDrainWaitCompletionAnnotation = "autopilot.webdevops.io/drain-wait-completion"
+	// pod is safe to evict immediately, other drain annotations are ignored
+	PodDrainSafeAnnotation = "autopilot.webdevops.io/drain-safe"
+
+	PodDrainDeferralReasonNotBefore      = "not-before"
+	PodDrainDeferralReasonWaitCompletion = "wait-completion"
+)
+
+type (
+	PodDrainDeferral struct {
+		Namespace string    `json:"namespace"`
+		Name      string    `json:"name"`
+		Reason    string    `json:"reason"`
+		Until     time.Time `json:"until"`
+	}
+)
+
+// list pods scheduled on node
+func (n *Node) Pods(ctx context.Context) ([]v1.Pod, error) {
+	listOpts := metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", n.Name),
+	}
+	podList, err := n.Client.CoreV1().Pods("").List(ctx, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+// pods on node which are deferring the drain (based on pod annotations)
+func (n *Node) DrainDeferringPods(ctx context.Context) ([]PodDrainDeferral, error) {
+	pods, err := n.Pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []PodDrainDeferral{}
+	for _, pod := range pods {
+		if deferral := GetPodDrainDeferral(pod, time.Now()); deferral != nil {
+			ret = append(ret, *deferral)
+		}
+	}
+	return ret, nil
+}
+
+// checks drain annotations of pod, returns nil if pod is not deferring the drain
+func GetPodDrainDeferral(pod v1.Pod, now time.Time) *PodDrainDeferral {
+	// finished pods are not affected by drain
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return nil
+	}
+
+	if strings.EqualFold(pod.Annotations[PodDrainSafeAnnotation], "true") {
+		return nil
+	}
+
+	deferral := PodDrainDeferral{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+	}
+
+	if val, exists := pod.Annotations[PodDrainNotBeforeAnnotation]; exists {
+		if notBefore, err := time.Parse(time.RFC3339, val); err == nil && now.Before(notBefore) {
+			deferral.Reason = PodDrainDeferralReasonNotBefore
+			deferral.Until = notBefore
+		}
+	}
+
+	if val, exists := pod.Annotations[PodDrainWaitCompletionAnnotation]; exists && pod.Status.StartTime != nil {
+		if waitDuration, err := time.ParseDuration(val); err == nil {
+			deadline := pod.Status.StartTime.Add(waitDuration)
+			if now.Before(deadline) && deadline.After(deferral.Until) {
+				deferral.Reason = PodDrainDeferralReasonWaitCompletion
+				deferral.Until = deadline
+			}
+		}
+	}
+
+	if deferral.Reason == "" {
+		return nil
+	}
+
+	return &deferral
+}
+
+// check if pod is running and ready
+func PodIsReady(pod v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPodDrainDeferral(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	started := metav1.NewTime(now.Add(-1 * time.Hour))
+
+	newPod := func(phase v1.PodPhase, annotations map[string]string) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", Annotations: annotations},
+			Status:     v1.PodStatus{Phase: phase, StartTime: &started},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		pod    v1.Pod
+		reason string
+		until  time.Time
+	}{
+		{
+			name: "no annotations",
+			pod:  newPod(v1.PodRunning, nil),
+		},
+		{
+			name:   "not before in future",
+			pod:    newPod(v1.PodRunning, map[string]string{PodDrainNotBeforeAnnotation: now.Add(2 * time.Hour).Format(time.RFC3339)}),
+			reason: PodDrainDeferralReasonNotBefore,
+			until:  now.Add(2 * time.Hour),
+		},
+		{
+			name: "not before in past",
+			pod:  newPod(v1.PodRunning, map[string]string{PodDrainNotBeforeAnnotation: now.Add(-2 * time.Hour).Format(time.RFC3339)}),
+		},
+		{
+			name: "invalid not before",
+			pod:  newPod(v1.PodRunning, map[string]string{PodDrainNotBeforeAnnotation: "tomorrow"}),
+		},
+		{
+			name:   "wait completion not reached",
+			pod:    newPod(v1.PodRunning, map[string]string{PodDrainWaitCompletionAnnotation: "4h"}),
+			reason: PodDrainDeferralReasonWaitCompletion,
+			until:  now.Add(3 * time.Hour),
+		},
+		{
+			name: "wait completion reached",
+			pod:  newPod(v1.PodRunning, map[string]string{PodDrainWaitCompletionAnnotation: "30m"}),
+		},
+		{
+			name: "invalid wait completion",
+			pod:  newPod(v1.PodRunning, map[string]string{PodDrainWaitCompletionAnnotation: "forever"}),
+		},
+		{
+			name: "later deadline wins (wait completion)",
+			pod: newPod(v1.PodRunning, map[string]string{
+				PodDrainNotBeforeAnnotation:      now.Add(1 * time.Hour).Format(time.RFC3339),
+				PodDrainWaitCompletionAnnotation: "4h",
+			}),
+			reason: PodDrainDeferralReasonWaitCompletion,
+			until:  now.Add(3 * time.Hour),
+		},
+		{
+			name: "later deadline wins (not before)",
+			pod: newPod(v1.PodRunning, map[string]string{
+				PodDrainNotBeforeAnnotation:      now.Add(5 * time.Hour).Format(time.RFC3339),
+				PodDrainWaitCompletionAnnotation: "4h",
+			}),
+			reason: PodDrainDeferralReasonNotBefore,
+			until:  now.Add(5 * time.Hour),
+		},
+		{
+			name: "drain safe overrides annotations",
+			pod: newPod(v1.PodRunning, map[string]string{
+				PodDrainSafeAnnotation:      "True",
+				PodDrainNotBeforeAnnotation: now.Add(2 * time.Hour).Format(time.RFC3339),
+			}),
+		},
+		{
+			name: "succeeded pod",
+			pod:  newPod(v1.PodSucceeded, map[string]string{PodDrainWaitCompletionAnnotation: "4h"}),
+		},
+		{
+			name: "failed pod",
+			pod:  newPod(v1.PodFailed, map[string]string{PodDrainWaitCompletionAnnotation: "4h"}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deferral := GetPodDrainDeferral(test.pod, now)
+
+			if test.reason == "" {
+				if deferral != nil {
+					t.Fatalf("expected no deferral, got %+v", *deferral)
+				}
+				return
+			}
+
+			if deferral == nil {
+				t.Fatalf("expected deferral with reason %v, got none", test.reason)
+			}
+			if deferral.Reason != test.reason {
+				t.Errorf("expected reason %v, got %v", test.reason, deferral.Reason)
+			}
+			if !deferral.Until.Equal(test.until) {
+				t.Errorf("expected until %v, got %v", test.until, deferral.Until)
+			}
+			if deferral.Namespace != "default" || deferral.Name != "pod" {
+				t.Errorf("expected pod default/pod, got %v/%v", deferral.Namespace, deferral.Name)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -50,7 +51,7 @@ func main() {
 	pilot.Start()
 
 	logger.Infof("starting http server on %s", Opts.Server.Bind)
-	startHttpServer()
+	startHttpServer(&pilot)
 
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM) //nolint:staticcheck
@@ -84,7 +85,7 @@ func initArgparser() {
 }
 
 // start and handle prometheus handler
-func startHttpServer() {
+func startHttpServer(pilot *autopilot.AzureK8sAutopilot) {
 	mux := http.NewServeMux()
 
 	// healthz
@@ -96,11 +97,40 @@ func startHttpServer() {
 
 	// readyz
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if readyErr := pilot.Ready(); readyErr != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if _, err := fmt.Fprint(w, readyErr.Error()); err != nil {
+				logger.Error(err.Error())
+			}
+			return
+		}
+
 		if _, err := fmt.Fprint(w, "Ok"); err != nil {
 			logger.Error(err.Error())
 		}
 	})
 
+	// status
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(pilot.Status()); err != nil {
+			logger.Error(err.Error())
+		}
+	})
+
+	// plan report (decisions of latest runs)
+	mux.HandleFunc("/plan", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(pilot.Plan()); err != nil {
+			logger.Error(err.Error())
+		}
+	})
+
+	// alertmanager webhook receiver
+	if pilot.AlertmanagerEnabled() {
+		mux.HandleFunc("/alertmanager", pilot.AlertmanagerHandler)
+	}
+
 	mux.Handle("/metrics", tracing.RegisterAzureMetricAutoClean(promhttp.Handler()))
 
 	go func() {